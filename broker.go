@@ -0,0 +1,385 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+var ErrSubscriberClosed = errors.New("subscriber closed")
+
+// Message is one delivery of a published payload to one subscriber.
+type Message struct {
+	ID      uint64
+	Topic   string
+	Payload []byte
+	Attempt int // 1 on first delivery, incremented on every redelivery
+
+	sub *Subscriber
+}
+
+// Ack confirms the message was processed so it will not be redelivered.
+// It is a no-op for subscribers without an AckTimeout.
+func (m *Message) Ack() {
+	if m.sub != nil {
+		m.sub.ack(m.ID)
+	}
+}
+
+// BackpressurePolicy decides what happens when a subscriber's queue is full.
+type BackpressurePolicy int
+
+const (
+	// Block makes Publish wait for room in the queue.
+	Block BackpressurePolicy = iota
+	// DropNewest discards the message being published.
+	DropNewest
+	// DropOldest evicts the oldest queued message to make room.
+	DropOldest
+)
+
+// SubscriberOptions configures delivery to one subscriber.
+type SubscriberOptions struct {
+	QueueSize int // default 64
+	Policy    BackpressurePolicy
+
+	// AckTimeout enables at-least-once delivery: a message that is not
+	// acked within AckTimeout is delivered again. MaxAttempts bounds the
+	// number of deliveries, zero meaning unlimited.
+	AckTimeout  time.Duration
+	MaxAttempts int
+
+	// Handler switches the subscriber to callback mode. Messages are acked
+	// when it returns nil. When Handler is nil messages are read from C.
+	Handler func(*Message) error
+}
+
+type pendingMessage struct {
+	msg      Message
+	deadline time.Time
+}
+
+// Subscriber receives messages published to topics matching its pattern.
+type Subscriber struct {
+	C <-chan *Message
+
+	ref     SubRef
+	pattern string
+	opts    SubscriberOptions
+	queue   chan *Message
+	dropped atomic.Uint64
+
+	pending map[uint64]*pendingMessage
+	closed  bool
+	sending sync.WaitGroup
+	mutex   sync.Mutex
+	done    chan struct{}
+}
+
+// Pattern returns the topic pattern the subscriber was created with.
+func (s *Subscriber) Pattern() string {
+	return s.pattern
+}
+
+// Dropped returns how many messages were discarded by the backpressure
+// policy or after exhausting MaxAttempts.
+func (s *Subscriber) Dropped() uint64 {
+	return s.dropped.Load()
+}
+
+func (s *Subscriber) deliver(ctx context.Context, m Message) error {
+	s.mutex.Lock()
+	if s.closed {
+		s.mutex.Unlock()
+		return ErrSubscriberClosed
+	}
+	s.sending.Add(1)
+	if s.opts.AckTimeout > 0 {
+		s.pending[m.ID] = &pendingMessage{msg: m, deadline: time.Now().Add(s.opts.AckTimeout)}
+	}
+	s.mutex.Unlock()
+	defer s.sending.Done()
+
+	msg := m
+	msg.sub = s
+	switch s.opts.Policy {
+	case DropNewest:
+		select {
+		case s.queue <- &msg:
+		default:
+			s.drop(m.ID)
+		}
+	case DropOldest:
+		for {
+			select {
+			case s.queue <- &msg:
+				return nil
+			default:
+			}
+			select {
+			case old := <-s.queue:
+				s.drop(old.ID)
+			default:
+			}
+		}
+	default:
+		select {
+		case s.queue <- &msg:
+		case <-s.done:
+			return ErrSubscriberClosed
+		case <-ctx.Done():
+			s.ack(m.ID)
+			return ctx.Err()
+		}
+	}
+	return nil
+}
+
+func (s *Subscriber) drop(id uint64) {
+	s.dropped.Add(1)
+	s.ack(id)
+}
+
+func (s *Subscriber) ack(id uint64) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	delete(s.pending, id)
+}
+
+// redeliver resends every pending message whose ack deadline has passed.
+// Redelivery never blocks: a message that does not fit in the queue stays
+// pending and is retried on the next tick.
+func (s *Subscriber) redeliver(now time.Time) {
+	s.mutex.Lock()
+	if s.closed {
+		s.mutex.Unlock()
+		return
+	}
+	var due []Message
+	for id, p := range s.pending {
+		if now.Before(p.deadline) {
+			continue
+		}
+		if s.opts.MaxAttempts > 0 && p.msg.Attempt >= s.opts.MaxAttempts {
+			delete(s.pending, id)
+			s.dropped.Add(1)
+			continue
+		}
+		p.msg.Attempt++
+		p.deadline = now.Add(s.opts.AckTimeout)
+		due = append(due, p.msg)
+	}
+	s.sending.Add(1)
+	s.mutex.Unlock()
+	defer s.sending.Done()
+
+	for _, m := range due {
+		msg := m
+		msg.sub = s
+		select {
+		case s.queue <- &msg:
+		default:
+		}
+	}
+}
+
+func (s *Subscriber) redeliveryLoop() {
+	ticker := time.NewTicker(s.opts.AckTimeout / 2)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-s.done:
+			return
+		case now := <-ticker.C:
+			s.redeliver(now)
+		}
+	}
+}
+
+func (s *Subscriber) handlerLoop() {
+	for m := range s.queue {
+		if err := s.opts.Handler(m); err == nil {
+			m.Ack()
+		}
+	}
+}
+
+func (s *Subscriber) close() {
+	s.mutex.Lock()
+	if s.closed {
+		s.mutex.Unlock()
+		return
+	}
+	s.closed = true
+	s.mutex.Unlock()
+
+	close(s.done)
+	s.sending.Wait()
+	close(s.queue)
+}
+
+// Broker is an in-process publish/subscribe broker. Topics are dot separated
+// ("orders.eu.created"); subscription patterns may use "*" to match exactly
+// one token and a trailing ">" to match one or more tokens.
+type Broker struct {
+	registry *SubscriptionRegistry
+	patterns map[string]map[*Subscriber]struct{}
+	log      *TopicLog
+	hooks    map[*publishHook]struct{}
+	nextID   atomic.Uint64
+	mutex    sync.RWMutex
+}
+
+type publishHook struct {
+	fn func(Message)
+}
+
+func NewBroker() *Broker {
+	b := &Broker{
+		registry: NewSubscriptionRegistry(),
+		patterns: make(map[string]map[*Subscriber]struct{}),
+		hooks:    make(map[*publishHook]struct{}),
+	}
+	// Called from registry.Unsubscribe, which the broker only invokes with
+	// its own lock held.
+	b.registry.OnTeardown = func(pattern string) {
+		delete(b.patterns, pattern)
+	}
+	return b
+}
+
+// PersistTo makes Publish append every message to l before delivering it,
+// so messages survive even when nobody is subscribed.
+func (b *Broker) PersistTo(l *TopicLog) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	b.log = l
+}
+
+// OnPublish calls fn with every message Publish accepts, before it is
+// delivered. fn runs under the lock Subscribe takes, so any subscriber sees
+// a given message either live or because fn had already been called with it.
+// fn must be fast and must not call back into the broker. The returned
+// function removes the hook.
+func (b *Broker) OnPublish(fn func(Message)) (remove func()) {
+	h := &publishHook{fn: fn}
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	b.hooks[h] = struct{}{}
+	return func() {
+		b.mutex.Lock()
+		defer b.mutex.Unlock()
+		delete(b.hooks, h)
+	}
+}
+
+// Subscribe registers componentUID for messages on topics matching pattern.
+func (b *Broker) Subscribe(componentUID, pattern string, opts SubscriberOptions) *Subscriber {
+	if opts.QueueSize <= 0 {
+		opts.QueueSize = 64
+	}
+	s := &Subscriber{
+		pattern: pattern,
+		opts:    opts,
+		queue:   make(chan *Message, opts.QueueSize),
+		pending: make(map[uint64]*pendingMessage),
+		done:    make(chan struct{}),
+	}
+	s.C = s.queue
+
+	b.mutex.Lock()
+	s.ref = b.registry.Subscribe(componentUID, pattern)
+	if b.patterns[pattern] == nil {
+		b.patterns[pattern] = make(map[*Subscriber]struct{})
+	}
+	b.patterns[pattern][s] = struct{}{}
+	b.mutex.Unlock()
+
+	if opts.AckTimeout > 0 {
+		go s.redeliveryLoop()
+	}
+	if opts.Handler != nil {
+		go s.handlerLoop()
+	}
+	return s
+}
+
+// Unsubscribe stops delivery to s and closes its channel.
+func (b *Broker) Unsubscribe(s *Subscriber) error {
+	b.mutex.Lock()
+	subs, ok := b.patterns[s.pattern]
+	if _, found := subs[s]; !ok || !found {
+		b.mutex.Unlock()
+		return ErrSubscriptionNotFound
+	}
+	delete(subs, s)
+	err := b.registry.Unsubscribe(s.ref)
+	b.mutex.Unlock()
+
+	s.close()
+	return err
+}
+
+// Publish delivers payload to every subscriber whose pattern matches topic
+// and returns the message ID. With the Block policy it waits for room in
+// each subscriber's queue until ctx is done. If the broker persists to a
+// TopicLog and the append fails, nothing is delivered.
+func (b *Broker) Publish(ctx context.Context, topic string, payload []byte) (uint64, error) {
+	m := Message{ID: b.nextID.Add(1), Topic: topic, Payload: payload, Attempt: 1}
+
+	b.mutex.RLock()
+	if b.log != nil {
+		if _, _, err := b.log.Append(topic, nil, payload); err != nil {
+			b.mutex.RUnlock()
+			return 0, err
+		}
+	}
+	for h := range b.hooks {
+		h.fn(m)
+	}
+	var targets []*Subscriber
+	for pattern, subs := range b.patterns {
+		if !matchTopic(pattern, topic) {
+			continue
+		}
+		for s := range subs {
+			targets = append(targets, s)
+		}
+	}
+	b.mutex.RUnlock()
+
+	var errs []error
+	for _, s := range targets {
+		if err := s.deliver(ctx, m); err != nil && err != ErrSubscriberClosed {
+			errs = append(errs, err)
+		}
+	}
+	return m.ID, errors.Join(errs...)
+}
+
+// Registry exposes the broker's subscription bookkeeping.
+func (b *Broker) Registry() *SubscriptionRegistry {
+	return b.registry
+}
+
+// matchTopic reports whether topic matches pattern, where "*" matches one
+// token and a final ">" matches all remaining tokens (at least one).
+func matchTopic(pattern, topic string) bool {
+	pt := strings.Split(pattern, ".")
+	tt := strings.Split(topic, ".")
+	for i, p := range pt {
+		if p == ">" && i == len(pt)-1 {
+			return len(tt) > i
+		}
+		if i >= len(tt) {
+			return false
+		}
+		if p != "*" && p != tt[i] {
+			return false
+		}
+	}
+	return len(pt) == len(tt)
+}
@@ -0,0 +1,169 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestMatchTopic(t *testing.T) {
+	tests := []struct {
+		pattern string
+		topic   string
+		want    bool
+	}{
+		{"orders", "orders", true},
+		{"orders", "orders.created", false},
+		{"orders.*", "orders.created", true},
+		{"orders.*", "orders", false},
+		{"orders.*", "orders.eu.created", false},
+		{"orders.>", "orders.created", true},
+		{"orders.>", "orders.eu.created", true},
+		{"orders.>", "orders", false},
+		{"*.created", "orders.created", true},
+		{"orders.*.created", "orders.eu.created", true},
+		{">", "anything.at.all", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.pattern+" "+tt.topic, func(t *testing.T) {
+			if got := matchTopic(tt.pattern, tt.topic); got != tt.want {
+				t.Errorf("matchTopic(%q, %q) = %v, want %v", tt.pattern, tt.topic, got, tt.want)
+			}
+		})
+	}
+}
+
+func receive(t *testing.T, s *Subscriber) *Message {
+	t.Helper()
+	select {
+	case m := <-s.C:
+		return m
+	case <-time.After(time.Second):
+		t.Fatal("timed out waiting for message")
+		return nil
+	}
+}
+
+func TestBrokerFanOut(t *testing.T) {
+	b := NewBroker()
+	exact := b.Subscribe("billing", "orders.created", SubscriberOptions{})
+	wildcard := b.Subscribe("audit", "orders.>", SubscriberOptions{})
+	other := b.Subscribe("shipping", "shipments.*", SubscriberOptions{})
+
+	if _, err := b.Publish(context.Background(), "orders.created", []byte("42")); err != nil {
+		t.Fatalf("Publish() error = %v", err)
+	}
+
+	for _, s := range []*Subscriber{exact, wildcard} {
+		if m := receive(t, s); string(m.Payload) != "42" {
+			t.Errorf("%s received %q, want %q", s.Pattern(), m.Payload, "42")
+		}
+	}
+	select {
+	case m := <-other.C:
+		t.Errorf("non-matching subscriber received %v", m)
+	default:
+	}
+
+	if err := b.Unsubscribe(exact); err != nil {
+		t.Fatalf("Unsubscribe() error = %v", err)
+	}
+	if _, ok := <-exact.C; ok {
+		t.Error("channel still open after Unsubscribe")
+	}
+	if err := b.Unsubscribe(exact); err != ErrSubscriptionNotFound {
+		t.Errorf("second Unsubscribe() error = %v, want %v", err, ErrSubscriptionNotFound)
+	}
+}
+
+func TestBrokerBackpressure(t *testing.T) {
+	tests := []struct {
+		name      string
+		policy    BackpressurePolicy
+		wantFirst string
+	}{
+		{name: "drop newest", policy: DropNewest, wantFirst: "1"},
+		{name: "drop oldest", policy: DropOldest, wantFirst: "3"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b := NewBroker()
+			s := b.Subscribe("c", "events", SubscriberOptions{QueueSize: 2, Policy: tt.policy})
+			for _, p := range []string{"1", "2", "3", "4"} {
+				b.Publish(context.Background(), "events", []byte(p))
+			}
+			if got := string(receive(t, s).Payload); got != tt.wantFirst {
+				t.Errorf("first message = %q, want %q", got, tt.wantFirst)
+			}
+			if got := s.Dropped(); got != 2 {
+				t.Errorf("Dropped() = %d, want 2", got)
+			}
+		})
+	}
+
+	t.Run("block", func(t *testing.T) {
+		b := NewBroker()
+		b.Subscribe("c", "events", SubscriberOptions{QueueSize: 1, Policy: Block})
+		b.Publish(context.Background(), "events", nil)
+
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+		defer cancel()
+		if _, err := b.Publish(ctx, "events", nil); !errors.Is(err, context.DeadlineExceeded) {
+			t.Errorf("Publish() to a full queue error = %v, want %v", err, context.DeadlineExceeded)
+		}
+	})
+}
+
+func TestBrokerRedelivery(t *testing.T) {
+	b := NewBroker()
+	s := b.Subscribe("c", "jobs", SubscriberOptions{AckTimeout: 20 * time.Millisecond, MaxAttempts: 3})
+	defer b.Unsubscribe(s)
+
+	b.Publish(context.Background(), "jobs", []byte("work"))
+
+	first := receive(t, s)
+	second := receive(t, s)
+	if second.ID != first.ID || second.Attempt != 2 {
+		t.Errorf("redelivery = (id %d, attempt %d), want (id %d, attempt 2)", second.ID, second.Attempt, first.ID)
+	}
+	second.Ack()
+
+	select {
+	case m := <-s.C:
+		t.Errorf("message redelivered after Ack: attempt %d", m.Attempt)
+	case <-time.After(60 * time.Millisecond):
+	}
+}
+
+func TestBrokerHandler(t *testing.T) {
+	b := NewBroker()
+	got := make(chan int, 4)
+	calls := 0
+	s := b.Subscribe("c", "jobs", SubscriberOptions{
+		AckTimeout: 20 * time.Millisecond,
+		Handler: func(m *Message) error {
+			calls++
+			got <- m.Attempt
+			if calls == 1 {
+				return errors.New("transient failure")
+			}
+			return nil
+		},
+	})
+	defer b.Unsubscribe(s)
+
+	b.Publish(context.Background(), "jobs", nil)
+	for want := 1; want <= 2; want++ {
+		select {
+		case attempt := <-got:
+			if attempt != want {
+				t.Errorf("handler saw attempt %d, want %d", attempt, want)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("handler not called for attempt %d", want)
+		}
+	}
+}
@@ -1,50 +1,19 @@
 package main
 
 import (
+	"errors"
 	"fmt"
 	"net/http"
 	"sync"
-	"time"
+	"sync/atomic"
 )
 
-type RateLimiter struct {
-	tokens    int
-	maxTokens int
-	rate      time.Duration
-	mutex    sync.Mutex
-}
-
-func NewRateLimiter(maxTokens int, ratePerSecond int) *RateLimiter {
-	rl := &RateLimiter{
-		tokens:    maxTokens,
-		maxTokens: maxTokens,
-		rate:      time.Second / time.Duration(ratePerSecond),
-	}
-	go rl.refill()
-	return rl
-}
-
-func (rl *RateLimiter) refill() {
-	ticker := time.NewTicker(rl.rate)
-	defer ticker.Stop()
-	for {
-		<-ticker.C
-		rl.mutex.Lock()
-		if rl.tokens < rl.maxTokens {
-			rl.tokens++
-		}
-		rl.mutex.Unlock()
-	}
-}
+var ErrSubscriptionNotFound = errors.New("subscription not found")
 
-func (rl *RateLimiter) AllowRequest() bool {
-	rl.mutex.Lock()
-	defer rl.mutex.Unlock()
-	if rl.tokens > 0 {
-		rl.tokens--
-		return true
-	}
-	return false
+// SubRef is the handle a component holds for one of its subscriptions.
+type SubRef interface {
+	GetComponentUID() string
+	GetSubscriptionUID() uint64
 }
 
 type intSubRef struct {
@@ -60,8 +29,13 @@ func (i *intSubRef) GetSubscriptionUID() uint64 {
 	return i.subID
 }
 
+// internalSubscription is the single underlying subscription to a topic,
+// shared by every component subscribed to it. mapComponent counts how many
+// times each component has subscribed.
 type internalSubscription struct {
-	mapComponent map[string]bool
+	subID        uint64
+	topic        string
+	mapComponent map[string]int
 	mapMutex     sync.Mutex
 }
 
@@ -71,16 +45,133 @@ func (i *internalSubscription) isEmpty() bool {
 	return len(i.mapComponent) == 0
 }
 
-func rateLimitedHandler(rl *RateLimiter) http.HandlerFunc {
-	return func(w http.ResponseWriter, r *http.Request) {
-		if rl.AllowRequest() {
-			w.WriteHeader(http.StatusOK)
-			fmt.Fprintln(w, "Request allowed")
-		} else {
-			w.WriteHeader(http.StatusTooManyRequests)
-			fmt.Fprintln(w, "Too many requests. Slow down!")
+func (i *internalSubscription) add(componentUID string) {
+	i.mapMutex.Lock()
+	defer i.mapMutex.Unlock()
+	i.mapComponent[componentUID]++
+}
+
+func (i *internalSubscription) remove(componentUID string) bool {
+	i.mapMutex.Lock()
+	defer i.mapMutex.Unlock()
+	n, ok := i.mapComponent[componentUID]
+	if !ok {
+		return false
+	}
+	if n <= 1 {
+		delete(i.mapComponent, componentUID)
+	} else {
+		i.mapComponent[componentUID] = n - 1
+	}
+	return true
+}
+
+func (i *internalSubscription) components() []string {
+	i.mapMutex.Lock()
+	defer i.mapMutex.Unlock()
+	uids := make([]string, 0, len(i.mapComponent))
+	for uid := range i.mapComponent {
+		uids = append(uids, uid)
+	}
+	return uids
+}
+
+// SubscriptionRegistry tracks which components are subscribed to which
+// topics. Components subscribing to the same topic share one
+// internalSubscription, which is torn down when the last reference to it is
+// released.
+type SubscriptionRegistry struct {
+	nextID  atomic.Uint64
+	byTopic map[string]*internalSubscription
+	byID    map[uint64]*internalSubscription
+	mutex   sync.Mutex
+
+	// OnSetup and OnTeardown, if set, are called when a topic gains its
+	// first subscriber and loses its last one. They run with the registry
+	// locked and must not call back into it.
+	OnSetup    func(topic string)
+	OnTeardown func(topic string)
+}
+
+func NewSubscriptionRegistry() *SubscriptionRegistry {
+	return &SubscriptionRegistry{
+		byTopic: make(map[string]*internalSubscription),
+		byID:    make(map[uint64]*internalSubscription),
+	}
+}
+
+func (r *SubscriptionRegistry) Subscribe(componentUID, topic string) SubRef {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	sub, ok := r.byTopic[topic]
+	if !ok {
+		sub = &internalSubscription{
+			subID:        r.nextID.Add(1),
+			topic:        topic,
+			mapComponent: make(map[string]int),
+		}
+		r.byTopic[topic] = sub
+		r.byID[sub.subID] = sub
+		if r.OnSetup != nil {
+			r.OnSetup(topic)
 		}
 	}
+	sub.add(componentUID)
+	return &intSubRef{subID: sub.subID, componentUID: componentUID}
+}
+
+// Unsubscribe releases one reference held by ref's component. Subscribing
+// twice requires unsubscribing twice.
+func (r *SubscriptionRegistry) Unsubscribe(ref SubRef) error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	sub, ok := r.byID[ref.GetSubscriptionUID()]
+	if !ok || !sub.remove(ref.GetComponentUID()) {
+		return ErrSubscriptionNotFound
+	}
+	if sub.isEmpty() {
+		delete(r.byTopic, sub.topic)
+		delete(r.byID, sub.subID)
+		if r.OnTeardown != nil {
+			r.OnTeardown(sub.topic)
+		}
+	}
+	return nil
+}
+
+// Topic returns the topic of the subscription ref belongs to.
+func (r *SubscriptionRegistry) Topic(ref SubRef) (string, bool) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	sub, ok := r.byID[ref.GetSubscriptionUID()]
+	if !ok {
+		return "", false
+	}
+	return sub.topic, true
+}
+
+// Components returns the UIDs of the components subscribed to topic.
+func (r *SubscriptionRegistry) Components(topic string) []string {
+	r.mutex.Lock()
+	sub, ok := r.byTopic[topic]
+	r.mutex.Unlock()
+	if !ok {
+		return nil
+	}
+	return sub.components()
+}
+
+// Topics returns every topic with at least one subscriber.
+func (r *SubscriptionRegistry) Topics() []string {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	topics := make([]string, 0, len(r.byTopic))
+	for topic := range r.byTopic {
+		topics = append(topics, topic)
+	}
+	return topics
 }
 
 func main() {
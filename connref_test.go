@@ -0,0 +1,68 @@
+package main
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+)
+
+func TestSubscriptionRegistryRefCounting(t *testing.T) {
+	r := NewSubscriptionRegistry()
+	var torndown []string
+	r.OnTeardown = func(topic string) { torndown = append(torndown, topic) }
+
+	a1 := r.Subscribe("component-a", "orders")
+	a2 := r.Subscribe("component-a", "orders")
+	b := r.Subscribe("component-b", "orders")
+
+	if a1.GetSubscriptionUID() != b.GetSubscriptionUID() {
+		t.Errorf("subscriptions to the same topic have different IDs: %d and %d",
+			a1.GetSubscriptionUID(), b.GetSubscriptionUID())
+	}
+
+	steps := []struct {
+		name         string
+		ref          SubRef
+		wantErr      error
+		wantTeardown int
+	}{
+		{name: "first of two references", ref: a1, wantTeardown: 0},
+		{name: "second reference", ref: a2, wantTeardown: 0},
+		{name: "already released", ref: a2, wantErr: ErrSubscriptionNotFound, wantTeardown: 0},
+		{name: "last component", ref: b, wantTeardown: 1},
+		{name: "after teardown", ref: b, wantErr: ErrSubscriptionNotFound, wantTeardown: 1},
+	}
+	for _, s := range steps {
+		if err := r.Unsubscribe(s.ref); err != s.wantErr {
+			t.Errorf("%s: Unsubscribe() error = %v, want %v", s.name, err, s.wantErr)
+		}
+		if len(torndown) != s.wantTeardown {
+			t.Errorf("%s: %d teardowns, want %d", s.name, len(torndown), s.wantTeardown)
+		}
+	}
+	if topics := r.Topics(); len(topics) != 0 {
+		t.Errorf("Topics() = %v after all unsubscribed, want none", topics)
+	}
+}
+
+func TestSubscriptionRegistryConcurrentIDs(t *testing.T) {
+	r := NewSubscriptionRegistry()
+	var wg sync.WaitGroup
+	refs := make([]SubRef, 100)
+	for i := range refs {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			refs[i] = r.Subscribe("component", fmt.Sprintf("topic-%d", i))
+		}(i)
+	}
+	wg.Wait()
+
+	seen := make(map[uint64]bool)
+	for _, ref := range refs {
+		if seen[ref.GetSubscriptionUID()] {
+			t.Fatalf("duplicate subscription ID %d", ref.GetSubscriptionUID())
+		}
+		seen[ref.GetSubscriptionUID()] = true
+	}
+}
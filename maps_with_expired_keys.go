@@ -1,29 +1,48 @@
 package main
 
 import (
+	"errors"
 	"fmt"
 	"sync"
 	"time"
 )
 
+// ErrNotCounter is returned by Incr and CompareAndSwap when the key holds a
+// value other than an int64, e.g. one stored with Set.
+var ErrNotCounter = errors.New("value is not an int64 counter")
+
 type item struct {
 	value      interface{}
 	expiration int64
 }
 
 type ExpiringMap struct {
-	mutex sync.Mutex
-	store map[string]item
+	mutex     sync.Mutex
+	store     map[string]item
+	done      chan struct{}
+	closeOnce sync.Once
 }
 
+// NewExpiringMap starts a goroutine that removes expired keys every
+// cleanupInterval, which must be positive. Call Close to stop it.
 func NewExpiringMap(cleanupInterval time.Duration) *ExpiringMap {
+	if cleanupInterval <= 0 {
+		panic("NewExpiringMap: non-positive cleanup interval")
+	}
 	em := &ExpiringMap{
 		store: make(map[string]item),
+		done:  make(chan struct{}),
 	}
 	go em.cleanupExpiredKeys(cleanupInterval)
 	return em
 }
 
+// Close stops the cleanup goroutine. The map stays usable, but expired keys
+// are then only hidden by Get rather than removed.
+func (em *ExpiringMap) Close() {
+	em.closeOnce.Do(func() { close(em.done) })
+}
+
 func (em *ExpiringMap) Set(key string, value interface{}, duration time.Duration) {
 	em.mutex.Lock()
 	defer em.mutex.Unlock()
@@ -44,9 +63,58 @@ func (em *ExpiringMap) Get(key string) (interface{}, bool) {
 	return item.value, true
 }
 
+// Incr atomically adds delta to the int64 stored at key and returns the new
+// value. A missing or expired key starts from zero and expires after ttl;
+// an existing key keeps its expiration.
+func (em *ExpiringMap) Incr(key string, delta int64, ttl time.Duration) (int64, error) {
+	em.mutex.Lock()
+	defer em.mutex.Unlock()
+
+	now := time.Now().UnixNano()
+	it, found := em.store[key]
+	if !found || now > it.expiration {
+		it = item{value: int64(0), expiration: now + int64(ttl)}
+	}
+	current, ok := it.value.(int64)
+	if !ok {
+		return 0, fmt.Errorf("incr %q: %w", key, ErrNotCounter)
+	}
+	n := current + delta
+	it.value = n
+	em.store[key] = it
+	return n, nil
+}
+
+// CompareAndSwap stores new at key with the given ttl only if the current
+// int64 value equals old. A missing or expired key compares equal to zero.
+func (em *ExpiringMap) CompareAndSwap(key string, old, new int64, ttl time.Duration) (bool, error) {
+	em.mutex.Lock()
+	defer em.mutex.Unlock()
+
+	now := time.Now().UnixNano()
+	var current int64
+	if it, found := em.store[key]; found && now <= it.expiration {
+		var ok bool
+		if current, ok = it.value.(int64); !ok {
+			return false, fmt.Errorf("compare and swap %q: %w", key, ErrNotCounter)
+		}
+	}
+	if current != old {
+		return false, nil
+	}
+	em.store[key] = item{value: new, expiration: now + int64(ttl)}
+	return true, nil
+}
+
 func (em *ExpiringMap) cleanupExpiredKeys(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
 	for {
-		time.Sleep(interval)
+		select {
+		case <-em.done:
+			return
+		case <-ticker.C:
+		}
 		em.mutex.Lock()
 		for key, item := range em.store {
 			if time.Now().UnixNano() > item.expiration {
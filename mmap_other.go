@@ -0,0 +1,26 @@
+//go:build !(linux || darwin)
+
+package main
+
+import (
+	"errors"
+	"io"
+	"os"
+)
+
+// mmapFile falls back to reading the file into memory where mmap is not
+// wired up. Only read-only mappings can be emulated this way.
+func mmapFile(f *os.File, size int, writable bool) ([]byte, error) {
+	if writable {
+		return nil, errors.ErrUnsupported
+	}
+	b := make([]byte, size)
+	if _, err := io.ReadFull(io.NewSectionReader(f, 0, int64(size)), b); err != nil {
+		return nil, err
+	}
+	return b, nil
+}
+
+func munmap(b []byte) error {
+	return nil
+}
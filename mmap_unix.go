@@ -0,0 +1,39 @@
+//go:build linux || darwin
+
+package main
+
+import (
+	"os"
+	"syscall"
+	"unsafe"
+)
+
+// mmapFile maps the first size bytes of f into memory. Writes through a
+// writable mapping go straight to the page cache and thus to the file.
+func mmapFile(f *os.File, size int, writable bool) ([]byte, error) {
+	prot := syscall.PROT_READ
+	if writable {
+		prot |= syscall.PROT_WRITE
+	}
+	return syscall.Mmap(int(f.Fd()), 0, size, prot, syscall.MAP_SHARED)
+}
+
+func munmap(b []byte) error {
+	return syscall.Munmap(b)
+}
+
+// msync flushes the pages covering b[off:off+n] to disk.
+func msync(b []byte, off, n int) error {
+	page := os.Getpagesize()
+	start := off &^ (page - 1)
+	end := min(off+n, len(b))
+	if end <= start {
+		return nil
+	}
+	_, _, errno := syscall.Syscall(syscall.SYS_MSYNC,
+		uintptr(unsafe.Pointer(&b[start])), uintptr(end-start), syscall.MS_SYNC)
+	if errno != 0 {
+		return errno
+	}
+	return nil
+}
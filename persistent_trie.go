@@ -0,0 +1,189 @@
+package main
+
+import (
+	"iter"
+	"slices"
+	"sync"
+	"sync/atomic"
+)
+
+// PersistentTrie is a Trie that is safe for concurrent use without readers
+// ever blocking. Nodes are never modified once published: a write copies
+// the path from the root to the changed node, shares every other subtree
+// with the previous version, and atomically swaps in the new root. Writers
+// are serialized by a mutex; readers just load the current root.
+type PersistentTrie struct {
+	root atomic.Pointer[Node]
+	keyOptions
+	writeMutex sync.Mutex
+}
+
+func NewPersistentTrie(opts ...TrieOption) *PersistentTrie {
+	p := &PersistentTrie{keyOptions: newKeyOptions(opts)}
+	p.root.Store(&Node{})
+	return p
+}
+
+// Snapshot returns a read-only view of the trie as it is now. Later writes
+// do not affect it, so a caller can run several queries against one
+// consistent version.
+func (p *PersistentTrie) Snapshot() *TrieSnapshot {
+	return &TrieSnapshot{t: &Trie{root: p.root.Load(), keyOptions: p.keyOptions}}
+}
+
+func (p *PersistentTrie) Search(w string) bool {
+	return p.Snapshot().Search(w)
+}
+
+func (p *PersistentTrie) KeysWithPrefix(prefix string, limit int) []string {
+	return p.Snapshot().KeysWithPrefix(prefix, limit)
+}
+
+// Insert adds w. Inserting a word that is already present keeps its score.
+func (p *PersistentTrie) Insert(w string) {
+	p.insert(w, nil)
+}
+
+// InsertWithScore adds w, or updates its score if it is already present.
+func (p *PersistentTrie) InsertWithScore(w string, score float64) {
+	p.insert(w, &score)
+}
+
+func (p *PersistentTrie) insert(w string, score *float64) {
+	key := []rune(p.key(w))
+	p.writeMutex.Lock()
+	defer p.writeMutex.Unlock()
+	root, _ := cowInsert(p.root.Load(), key, score)
+	p.root.Store(root)
+}
+
+// cowInsert returns a copy of n with key inserted below it and whether key
+// is new. n itself is left untouched.
+func cowInsert(n *Node, key []rune, score *float64) (*Node, bool) {
+	c := *n
+	if len(key) == 0 {
+		added := !c.isEnd
+		c.isEnd = true
+		if added {
+			c.count++
+		}
+		if score != nil {
+			c.score = *score
+		}
+		return &c, added
+	}
+
+	i, ok := searchChildren(n.children, key[0])
+	c.children = slices.Clone(n.children)
+	var child *Node
+	var added bool
+	if ok {
+		child, added = cowInsert(n.children[i], key[1:], score)
+		c.children[i] = child
+	} else {
+		child, added = cowInsert(&Node{char: key[0]}, key[1:], score)
+		c.children = slices.Insert(c.children, i, child)
+	}
+	if added {
+		c.count++
+	}
+	return &c, added
+}
+
+// Delete removes word and reports whether it was present.
+func (p *PersistentTrie) Delete(word string) bool {
+	key := []rune(p.key(word))
+	p.writeMutex.Lock()
+	defer p.writeMutex.Unlock()
+	root, found := cowDelete(p.root.Load(), key)
+	if !found {
+		return false
+	}
+	if root == nil {
+		root = &Node{}
+	}
+	p.root.Store(root)
+	return true
+}
+
+// cowDelete returns a copy of n with key removed, or nil if the copy would
+// lead to no words and can be pruned. If key is absent n is returned as is.
+func cowDelete(n *Node, key []rune) (*Node, bool) {
+	if len(key) == 0 {
+		if !n.isEnd {
+			return n, false
+		}
+		if hasNoChildren(n) {
+			return nil, true
+		}
+		c := *n
+		c.isEnd, c.score = false, 0
+		c.count--
+		return &c, true
+	}
+
+	i, ok := searchChildren(n.children, key[0])
+	if !ok {
+		return n, false
+	}
+	child, found := cowDelete(n.children[i], key[1:])
+	if !found {
+		return n, false
+	}
+
+	c := *n
+	c.count--
+	if child == nil {
+		c.children = slices.Delete(slices.Clone(n.children), i, i+1)
+		if !c.isEnd && hasNoChildren(&c) {
+			return nil, true
+		}
+	} else {
+		c.children = slices.Clone(n.children)
+		c.children[i] = child
+	}
+	return &c, true
+}
+
+// TrieSnapshot is an immutable version of a PersistentTrie. It offers the
+// read side of the Trie API and is safe for concurrent use.
+type TrieSnapshot struct {
+	t *Trie
+}
+
+func (s *TrieSnapshot) Search(w string) bool {
+	return s.t.Search(w)
+}
+
+func (s *TrieSnapshot) StartsWith(prefix string) bool {
+	return s.t.StartsWith(prefix)
+}
+
+func (s *TrieSnapshot) CountWithPrefix(prefix string) int {
+	return s.t.CountWithPrefix(prefix)
+}
+
+func (s *TrieSnapshot) KeysWithPrefix(prefix string, limit int) []string {
+	return s.t.KeysWithPrefix(prefix, limit)
+}
+
+func (s *TrieSnapshot) Completions(prefix string) iter.Seq[string] {
+	return s.t.Completions(prefix)
+}
+
+func (s *TrieSnapshot) TopK(prefix string, k int) []Completion {
+	return s.t.TopK(prefix, k)
+}
+
+func (s *TrieSnapshot) FuzzySearch(word string, maxDistance int) []FuzzyMatch {
+	return s.t.FuzzySearch(word, maxDistance)
+}
+
+func (s *TrieSnapshot) WildcardSearch(pattern string) []string {
+	return s.t.WildcardSearch(pattern)
+}
+
+// Len returns the number of words in the snapshot.
+func (s *TrieSnapshot) Len() int {
+	return s.t.root.count
+}
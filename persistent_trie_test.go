@@ -0,0 +1,99 @@
+package main
+
+import (
+	"fmt"
+	"slices"
+	"sync"
+	"testing"
+)
+
+func TestPersistentTrieSnapshots(t *testing.T) {
+	p := NewPersistentTrie()
+	p.Insert("oreo")
+	p.Insert("oregon")
+	before := p.Snapshot()
+
+	p.Insert("ore")
+	p.Delete("oreo")
+	after := p.Snapshot()
+
+	if !before.Search("oreo") || before.Search("ore") || before.Len() != 2 {
+		t.Errorf("old snapshot changed: oreo=%v ore=%v Len=%d", before.Search("oreo"), before.Search("ore"), before.Len())
+	}
+	if got := after.KeysWithPrefix("ore", 0); !slices.Equal(got, []string{"ore", "oregon"}) {
+		t.Errorf("new snapshot KeysWithPrefix(\"ore\") = %v, want [ore oregon]", got)
+	}
+	if p.Delete("oreo") || p.Delete("") {
+		t.Error("Delete of a missing word succeeded")
+	}
+	p.Insert("")
+	if !p.Delete("") || p.Search("") || p.Snapshot().Len() != 2 {
+		t.Error("Delete(\"\") did not remove just the empty word")
+	}
+	p.Delete("ore")
+	p.Delete("oregon")
+	if s := p.Snapshot(); s.Len() != 0 || !hasNoChildren(s.t.root) {
+		t.Errorf("trie not empty after deleting every word: Len=%d", s.Len())
+	}
+}
+
+func TestPersistentTrieSharesUnchangedSubtrees(t *testing.T) {
+	p := NewPersistentTrie()
+	p.Insert("apple")
+	p.Insert("banana")
+	old := p.root.Load()
+
+	p.Insert("bandana")
+	root := p.root.Load()
+	if root == old {
+		t.Fatal("Insert modified the root in place")
+	}
+	if root.child('a') != old.child('a') {
+		t.Error("untouched subtree \"a\" was copied")
+	}
+	if root.child('b') == old.child('b') {
+		t.Error("changed subtree \"b\" was shared")
+	}
+	checkTrie(t, root)
+	checkTrie(t, old)
+}
+
+// TestPersistentTrieConcurrent is meant to be run with -race: readers query
+// snapshots while writers insert and delete.
+func TestPersistentTrieConcurrent(t *testing.T) {
+	p := NewPersistentTrie()
+	var wg sync.WaitGroup
+	for w := 0; w < 4; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < 200; i++ {
+				word := fmt.Sprintf("w%d-%d", w, i)
+				p.Insert(word)
+				if i%2 == 1 {
+					p.Delete(word)
+				}
+			}
+		}()
+	}
+	for r := 0; r < 4; r++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < 200; i++ {
+				s := p.Snapshot()
+				if n, keys := s.CountWithPrefix("w"), s.KeysWithPrefix("w", 0); n != len(keys) {
+					t.Errorf("snapshot CountWithPrefix = %d but KeysWithPrefix returned %d", n, len(keys))
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	s := p.Snapshot()
+	if s.Len() != 400 {
+		t.Errorf("Len() = %d, want 400", s.Len())
+	}
+	checkTrie(t, s.t.root)
+}
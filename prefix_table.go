@@ -0,0 +1,173 @@
+package main
+
+import (
+	"errors"
+	"net/netip"
+)
+
+var ErrInvalidPrefix = errors.New("invalid prefix")
+
+type prefixNode[V any] struct {
+	children [2]*prefixNode[V]
+	hasValue bool
+	value    V
+}
+
+// PrefixTable maps CIDR prefixes to values and finds the most specific
+// prefix containing an address, as needed for allow/deny lists and geo
+// tables. It is a binary trie branching on one address bit per level, with
+// separate roots for IPv4 and IPv6; IPv4-mapped IPv6 addresses are looked up
+// as IPv4.
+//
+// A PrefixTable is not safe for concurrent writes, but any number of
+// goroutines may look up addresses once it is built.
+type PrefixTable[V any] struct {
+	root4 prefixNode[V]
+	root6 prefixNode[V]
+	size  int
+}
+
+func NewPrefixTable[V any]() *PrefixTable[V] {
+	return &PrefixTable[V]{}
+}
+
+func (pt *PrefixTable[V]) Len() int {
+	return pt.size
+}
+
+func (pt *PrefixTable[V]) root(addr netip.Addr) *prefixNode[V] {
+	if addr.Is4() {
+		return &pt.root4
+	}
+	return &pt.root6
+}
+
+// addrBit returns bit i of addr, counting from the most significant.
+func addrBit(addr netip.Addr, i int) int {
+	if addr.Is4() {
+		b := addr.As4()
+		return int(b[i/8]>>(7-i%8)) & 1
+	}
+	b := addr.As16()
+	return int(b[i/8]>>(7-i%8)) & 1
+}
+
+// normalizePrefix masks off host bits and turns an IPv4-mapped IPv6 prefix
+// into the IPv4 prefix it covers.
+func normalizePrefix(p netip.Prefix) (netip.Prefix, error) {
+	if !p.IsValid() {
+		return netip.Prefix{}, ErrInvalidPrefix
+	}
+	if addr := p.Addr(); addr.Is4In6() {
+		if p.Bits() < 96 {
+			return netip.Prefix{}, ErrInvalidPrefix
+		}
+		p = netip.PrefixFrom(addr.Unmap(), p.Bits()-96)
+	}
+	return p.Masked(), nil
+}
+
+// Insert stores value for p, replacing any value already stored for exactly
+// that prefix. Host bits in p are ignored.
+func (pt *PrefixTable[V]) Insert(p netip.Prefix, value V) error {
+	p, err := normalizePrefix(p)
+	if err != nil {
+		return err
+	}
+	n := pt.root(p.Addr())
+	for i := 0; i < p.Bits(); i++ {
+		bit := addrBit(p.Addr(), i)
+		if n.children[bit] == nil {
+			n.children[bit] = &prefixNode[V]{}
+		}
+		n = n.children[bit]
+	}
+	if !n.hasValue {
+		n.hasValue = true
+		pt.size++
+	}
+	n.value = value
+	return nil
+}
+
+// Get returns the value stored for exactly p.
+func (pt *PrefixTable[V]) Get(p netip.Prefix) (V, bool) {
+	var zero V
+	p, err := normalizePrefix(p)
+	if err != nil {
+		return zero, false
+	}
+	n := pt.root(p.Addr())
+	for i := 0; i < p.Bits() && n != nil; i++ {
+		n = n.children[addrBit(p.Addr(), i)]
+	}
+	if n == nil || !n.hasValue {
+		return zero, false
+	}
+	return n.value, true
+}
+
+// Delete removes p and reports whether it was present, pruning branches
+// that no longer lead to a prefix.
+func (pt *PrefixTable[V]) Delete(p netip.Prefix) bool {
+	p, err := normalizePrefix(p)
+	if err != nil {
+		return false
+	}
+	path := make([]*prefixNode[V], 0, p.Bits()+1)
+	n := pt.root(p.Addr())
+	path = append(path, n)
+	for i := 0; i < p.Bits(); i++ {
+		if n = n.children[addrBit(p.Addr(), i)]; n == nil {
+			return false
+		}
+		path = append(path, n)
+	}
+	if !n.hasValue {
+		return false
+	}
+
+	var zero V
+	n.hasValue, n.value = false, zero
+	pt.size--
+	for i := len(path) - 1; i > 0; i-- {
+		if n := path[i]; n.hasValue || n.children[0] != nil || n.children[1] != nil {
+			break
+		}
+		path[i-1].children[addrBit(p.Addr(), i-1)] = nil
+	}
+	return true
+}
+
+// Lookup returns the longest stored prefix containing addr and its value.
+func (pt *PrefixTable[V]) Lookup(addr netip.Addr) (netip.Prefix, V, bool) {
+	var (
+		best      netip.Prefix
+		bestValue V
+		found     bool
+	)
+	if !addr.IsValid() {
+		return best, bestValue, false
+	}
+	addr = addr.Unmap().WithZone("")
+	n := pt.root(addr)
+	for i := 0; n != nil; i++ {
+		if n.hasValue {
+			best, bestValue, found = netip.PrefixFrom(addr, i), n.value, true
+		}
+		if i == addr.BitLen() {
+			break
+		}
+		n = n.children[addrBit(addr, i)]
+	}
+	if found {
+		best = best.Masked()
+	}
+	return best, bestValue, found
+}
+
+// Contains reports whether any stored prefix contains addr.
+func (pt *PrefixTable[V]) Contains(addr netip.Addr) bool {
+	_, _, ok := pt.Lookup(addr)
+	return ok
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"net/netip"
+	"testing"
+)
+
+func TestPrefixTableLookup(t *testing.T) {
+	pt := NewPrefixTable[string]()
+	for p, v := range map[string]string{
+		"0.0.0.0/0":       "default4",
+		"10.0.0.0/8":      "internal",
+		"10.1.0.0/16":     "office",
+		"10.1.2.3/32":     "gateway",
+		"192.168.1.7/24":  "lan", // host bits are ignored
+		"2001:db8::/32":   "doc6",
+		"2001:db8:1::/48": "doc6-site",
+	} {
+		if err := pt.Insert(netip.MustParsePrefix(p), v); err != nil {
+			t.Fatalf("Insert(%s) error = %v", p, err)
+		}
+	}
+	if pt.Len() != 7 {
+		t.Errorf("Len() = %d, want 7", pt.Len())
+	}
+
+	tests := []struct {
+		addr       string
+		wantPrefix string
+		want       string
+	}{
+		{addr: "10.1.2.3", wantPrefix: "10.1.2.3/32", want: "gateway"},
+		{addr: "10.1.2.4", wantPrefix: "10.1.0.0/16", want: "office"},
+		{addr: "10.200.0.1", wantPrefix: "10.0.0.0/8", want: "internal"},
+		{addr: "::ffff:10.9.9.9", wantPrefix: "10.0.0.0/8", want: "internal"},
+		{addr: "192.168.1.200", wantPrefix: "192.168.1.0/24", want: "lan"},
+		{addr: "8.8.8.8", wantPrefix: "0.0.0.0/0", want: "default4"},
+		{addr: "2001:db8:1:2::1", wantPrefix: "2001:db8:1::/48", want: "doc6-site"},
+		{addr: "2001:db8:2::1%eth0", wantPrefix: "2001:db8::/32", want: "doc6"},
+	}
+	for _, tt := range tests {
+		p, v, ok := pt.Lookup(netip.MustParseAddr(tt.addr))
+		if !ok || p.String() != tt.wantPrefix || v != tt.want {
+			t.Errorf("Lookup(%s) = (%s, %q, %v), want (%s, %q, true)", tt.addr, p, v, ok, tt.wantPrefix, tt.want)
+		}
+	}
+	if pt.Contains(netip.MustParseAddr("2001:db9::1")) {
+		t.Error("Contains(2001:db9::1) = true with no IPv6 default route")
+	}
+	if _, _, ok := pt.Lookup(netip.Addr{}); ok {
+		t.Error("Lookup of the zero Addr matched")
+	}
+}
+
+func TestPrefixTableDelete(t *testing.T) {
+	pt := NewPrefixTable[int]()
+	outer, inner := netip.MustParsePrefix("10.0.0.0/8"), netip.MustParsePrefix("10.1.0.0/16")
+	pt.Insert(outer, 1)
+	pt.Insert(inner, 2)
+	if err := pt.Insert(netip.Prefix{}, 3); err != ErrInvalidPrefix {
+		t.Errorf("Insert(invalid) error = %v, want %v", err, ErrInvalidPrefix)
+	}
+
+	if pt.Delete(netip.MustParsePrefix("10.1.0.0/17")) {
+		t.Error("Delete of a prefix that was never inserted succeeded")
+	}
+	if !pt.Delete(inner) || pt.Delete(inner) {
+		t.Error("Delete(inner) should succeed exactly once")
+	}
+	if _, v, _ := pt.Lookup(netip.MustParseAddr("10.1.0.1")); v != 1 {
+		t.Errorf("Lookup after deleting the inner prefix = %d, want 1", v)
+	}
+	if v, ok := pt.Get(outer); !ok || v != 1 {
+		t.Errorf("Get(outer) = (%d, %v), want (1, true)", v, ok)
+	}
+	pt.Delete(outer)
+	if pt.Len() != 0 || pt.root4.children != [2]*prefixNode[int]{} {
+		t.Error("table not pruned after deleting every prefix")
+	}
+}
@@ -0,0 +1,268 @@
+package main
+
+import (
+	"iter"
+	"slices"
+	"strings"
+)
+
+// radixNode is a node of a RadixTree. Its edge from the parent carries the
+// whole label rather than a single character, and no two children share a
+// first byte.
+type radixNode struct {
+	label    string
+	children []*radixNode // sorted by label[0]
+	isEnd    bool
+	count    int // words ending at or below this node
+	score    float64
+}
+
+func (n *radixNode) childIndex(b byte) (int, bool) {
+	return slices.BinarySearchFunc(n.children, b, func(c *radixNode, b byte) int {
+		return int(c.label[0]) - int(b)
+	})
+}
+
+func (n *radixNode) child(b byte) *radixNode {
+	if i, ok := n.childIndex(b); ok {
+		return n.children[i]
+	}
+	return nil
+}
+
+// absorbChild merges n with its only child, restoring the invariant that an
+// inner node which ends no word has at least two children.
+func (n *radixNode) absorbChild() {
+	c := n.children[0]
+	n.label += c.label
+	n.children = c.children
+	n.isEnd = c.isEnd
+	n.count = c.count
+	n.score = c.score
+}
+
+// RadixTree is a compressed trie (PATRICIA tree): chains of single-child
+// nodes are collapsed into one node whose edge label holds the whole run.
+// A dictionary then needs roughly one node per word instead of one per
+// character. It has the same API and TrieOptions as Trie; labels are split
+// on byte boundaries, which is invisible to callers because a word is only
+// ever reassembled in full.
+type RadixTree struct {
+	root *radixNode
+	keyOptions
+}
+
+func NewRadixTree(opts ...TrieOption) *RadixTree {
+	return &RadixTree{root: &radixNode{}, keyOptions: newKeyOptions(opts)}
+}
+
+func commonPrefixLen(a, b string) int {
+	n := min(len(a), len(b))
+	for i := 0; i < n; i++ {
+		if a[i] != b[i] {
+			return i
+		}
+	}
+	return n
+}
+
+// Insert adds w. Inserting a word that is already present keeps its score.
+func (t *RadixTree) Insert(w string) {
+	t.insert(w, nil)
+}
+
+// InsertWithScore adds w, or updates its score if it is already present.
+func (t *RadixTree) InsertWithScore(w string, score float64) {
+	t.insert(w, &score)
+}
+
+func (t *RadixTree) insert(w string, score *float64) {
+	rest := t.key(w)
+	n := t.root
+	path := []*radixNode{n}
+	for rest != "" {
+		i, ok := n.childIndex(rest[0])
+		if !ok {
+			leaf := &radixNode{label: rest}
+			n.children = slices.Insert(n.children, i, leaf)
+			n = leaf
+			path = append(path, n)
+			break
+		}
+
+		c := n.children[i]
+		common := commonPrefixLen(c.label, rest)
+		if common < len(c.label) {
+			// Split the edge: c keeps the tail of its label below a new
+			// node holding the shared part.
+			mid := &radixNode{label: c.label[:common], children: []*radixNode{c}, count: c.count}
+			c.label = c.label[common:]
+			n.children[i] = mid
+			c = mid
+		}
+		n = c
+		path = append(path, n)
+		rest = rest[common:]
+	}
+
+	if !n.isEnd {
+		n.isEnd = true
+		for _, p := range path {
+			p.count++
+		}
+	}
+	if score != nil {
+		n.score = *score
+	}
+}
+
+// find returns the node for key, or nil if key does not end on a node.
+func (t *RadixTree) find(key string) *radixNode {
+	n := t.root
+	for key != "" {
+		n = n.child(key[0])
+		if n == nil || !strings.HasPrefix(key, n.label) {
+			return nil
+		}
+		key = key[len(n.label):]
+	}
+	return n
+}
+
+// findPrefix returns the topmost node whose words all begin with prefix,
+// together with the full key leading to it, which may extend past prefix
+// when prefix ends inside an edge label.
+func (t *RadixTree) findPrefix(prefix string) (*radixNode, string) {
+	n := t.root
+	consumed := 0
+	for consumed < len(prefix) {
+		rest := prefix[consumed:]
+		n = n.child(rest[0])
+		if n == nil {
+			return nil, ""
+		}
+		if !strings.HasPrefix(rest, n.label) {
+			if strings.HasPrefix(n.label, rest) {
+				return n, prefix[:consumed] + n.label
+			}
+			return nil, ""
+		}
+		consumed += len(n.label)
+	}
+	return n, prefix
+}
+
+func (t *RadixTree) Search(w string) bool {
+	n := t.find(t.key(w))
+	return n != nil && n.isEnd
+}
+
+// Delete removes word and reports whether it was present, merging nodes so
+// that the tree stays compressed.
+func (t *RadixTree) Delete(word string) bool {
+	word = t.key(word)
+	path := []*radixNode{t.root}
+	n, rest := t.root, word
+	for rest != "" {
+		n = n.child(rest[0])
+		if n == nil || !strings.HasPrefix(rest, n.label) {
+			return false
+		}
+		path = append(path, n)
+		rest = rest[len(n.label):]
+	}
+	if !n.isEnd {
+		return false
+	}
+
+	n.isEnd = false
+	n.score = 0
+	for _, p := range path {
+		p.count--
+	}
+	if n == t.root {
+		// The empty word lives on the root, which is never merged away.
+		return true
+	}
+
+	parent := path[len(path)-2]
+	switch len(n.children) {
+	case 0:
+		i, _ := parent.childIndex(n.label[0])
+		parent.children = slices.Delete(parent.children, i, i+1)
+		if parent != t.root && !parent.isEnd && len(parent.children) == 1 {
+			parent.absorbChild()
+		}
+	case 1:
+		n.absorbChild()
+	}
+	return true
+}
+
+// StartsWith reports whether any word begins with prefix.
+func (t *RadixTree) StartsWith(prefix string) bool {
+	return t.CountWithPrefix(prefix) > 0
+}
+
+// CountWithPrefix returns the number of words beginning with prefix.
+func (t *RadixTree) CountWithPrefix(prefix string) int {
+	n, _ := t.findPrefix(t.key(prefix))
+	if n == nil {
+		return 0
+	}
+	return n.count
+}
+
+// KeysWithPrefix returns up to limit words beginning with prefix in
+// lexicographic order. A limit of zero or less returns them all.
+func (t *RadixTree) KeysWithPrefix(prefix string, limit int) []string {
+	var keys []string
+	for w := range t.Completions(prefix) {
+		if limit > 0 && len(keys) == limit {
+			break
+		}
+		keys = append(keys, w)
+	}
+	return keys
+}
+
+// Completions streams the words beginning with prefix in lexicographic
+// order.
+func (t *RadixTree) Completions(prefix string) iter.Seq[string] {
+	return func(yield func(string) bool) {
+		if n, key := t.findPrefix(t.key(prefix)); n != nil {
+			t.walk(n, []byte(key), func(n *radixNode, w []byte) bool {
+				return yield(string(w))
+			})
+		}
+	}
+}
+
+// TopK returns the k highest-scoring words beginning with prefix, best
+// first. Equal scores are ordered lexicographically.
+func (t *RadixTree) TopK(prefix string, k int) []Completion {
+	n, key := t.findPrefix(t.key(prefix))
+	if n == nil || k <= 0 {
+		return nil
+	}
+	best := make([]Completion, 0, k)
+	t.walk(n, []byte(key), func(n *radixNode, w []byte) bool {
+		best = pushTopK(best, k, Completion{Word: string(w), Score: n.score})
+		return true
+	})
+	return best
+}
+
+// walk calls fn for every node ending a word at or below n, in
+// lexicographic order, with buf holding that word.
+func (t *RadixTree) walk(n *radixNode, buf []byte, fn func(*radixNode, []byte) bool) bool {
+	if n.isEnd && !fn(n, buf) {
+		return false
+	}
+	for _, c := range n.children {
+		if !t.walk(c, append(buf, c.label...), fn) {
+			return false
+		}
+	}
+	return true
+}
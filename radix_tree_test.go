@@ -0,0 +1,224 @@
+package main
+
+import (
+	"math/rand"
+	"runtime"
+	"slices"
+	"testing"
+)
+
+// checkRadixTree verifies the compression invariants: non-empty labels,
+// children sorted by distinct first bytes, counts that add up and no inner
+// node that ends no word yet has a single child.
+func checkRadixTree(t *testing.T, n *radixNode, isRoot bool) {
+	t.Helper()
+	want := 0
+	if n.isEnd {
+		want = 1
+	}
+	for i, c := range n.children {
+		if c.label == "" {
+			t.Fatal("empty edge label")
+		}
+		if i > 0 && n.children[i-1].label[0] >= c.label[0] {
+			t.Fatalf("children of %q out of order", n.label)
+		}
+		want += c.count
+		checkRadixTree(t, c, false)
+	}
+	if n.count != want {
+		t.Fatalf("node %q count = %d, want %d", n.label, n.count, want)
+	}
+	if !isRoot && !n.isEnd && len(n.children) < 2 {
+		t.Fatalf("node %q is not compressed: %d children, isEnd false", n.label, len(n.children))
+	}
+}
+
+func TestRadixTree(t *testing.T) {
+	tree := NewRadixTree()
+	for _, w := range []string{"romane", "romanus", "romulus", "rubens", "ruber", "rubicon", "rubicundus", "東京", "東北"} {
+		tree.Insert(w)
+	}
+	checkRadixTree(t, tree.root, true)
+
+	if len(tree.root.children) != 2 {
+		t.Errorf("root has %d children, want 2 (r, 東)", len(tree.root.children))
+	}
+	for _, w := range []string{"romane", "rubicundus", "東北"} {
+		if !tree.Search(w) {
+			t.Errorf("Search(%q) = false", w)
+		}
+	}
+	for _, w := range []string{"rom", "rub", "東", "rubiconx"} {
+		if tree.Search(w) {
+			t.Errorf("Search(%q) = true for a prefix or extension", w)
+		}
+	}
+	if got := tree.KeysWithPrefix("rubi", 0); !slices.Equal(got, []string{"rubicon", "rubicundus"}) {
+		t.Errorf("KeysWithPrefix(\"rubi\") = %v, want [rubicon rubicundus]", got)
+	}
+	if n := tree.CountWithPrefix("r"); n != 7 {
+		t.Errorf("CountWithPrefix(\"r\") = %d, want 7", n)
+	}
+
+	if !tree.Delete("romane") || tree.Delete("romane") || tree.Delete("rom") {
+		t.Error("Delete should succeed once for a stored word and fail otherwise")
+	}
+	checkRadixTree(t, tree.root, true)
+	if !tree.Search("romanus") {
+		t.Error("Search(\"romanus\") = false after deleting \"romane\"")
+	}
+}
+
+// TestRadixTreeMatchesTrie applies the same random operations to a Trie and
+// a RadixTree and checks that every query agrees.
+func TestRadixTreeMatchesTrie(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	alphabet := []rune("abcé東")
+	word := func() string {
+		r := make([]rune, rng.Intn(6))
+		for i := range r {
+			r[i] = alphabet[rng.Intn(len(alphabet))]
+		}
+		return string(r)
+	}
+
+	trie, tree := InitTrie(), NewRadixTree()
+	for i := 0; i < 5000; i++ {
+		w := word()
+		switch rng.Intn(3) {
+		case 0, 1:
+			score := float64(rng.Intn(10))
+			trie.InsertWithScore(w, score)
+			tree.InsertWithScore(w, score)
+		case 2:
+			if a, b := trie.Delete(w), tree.Delete(w); a != b {
+				t.Fatalf("Delete(%q): trie %v, radix %v", w, a, b)
+			}
+		}
+
+		q := word()
+		if a, b := trie.Search(q), tree.Search(q); a != b {
+			t.Fatalf("Search(%q): trie %v, radix %v", q, a, b)
+		}
+		if a, b := trie.CountWithPrefix(q), tree.CountWithPrefix(q); a != b {
+			t.Fatalf("CountWithPrefix(%q): trie %d, radix %d", q, a, b)
+		}
+		if a, b := trie.KeysWithPrefix(q, 5), tree.KeysWithPrefix(q, 5); !slices.Equal(a, b) {
+			t.Fatalf("KeysWithPrefix(%q): trie %v, radix %v", q, a, b)
+		}
+		if a, b := trie.TopK(q, 3), tree.TopK(q, 3); !slices.Equal(a, b) {
+			t.Fatalf("TopK(%q): trie %v, radix %v", q, a, b)
+		}
+	}
+	checkRadixTree(t, tree.root, true)
+
+	// The empty word is stored on the root of both structures.
+	for _, op := range []string{"insert", "delete", "delete", "insert", "delete"} {
+		if op == "insert" {
+			trie.Insert("")
+			tree.Insert("")
+		} else if a, b := trie.Delete(""), tree.Delete(""); a != b {
+			t.Fatalf("Delete(\"\"): trie %v, radix %v", a, b)
+		}
+		if a, b := trie.Search(""), tree.Search(""); a != b {
+			t.Fatalf("Search(\"\") after %s: trie %v, radix %v", op, a, b)
+		}
+		if a, b := trie.CountWithPrefix(""), tree.CountWithPrefix(""); a != b {
+			t.Fatalf("CountWithPrefix(\"\") after %s: trie %d, radix %d", op, a, b)
+		}
+	}
+	checkRadixTree(t, tree.root, true)
+}
+
+// benchmarkDictionary returns n pseudo-random words with realistic shared
+// prefixes and suffixes.
+func benchmarkDictionary(n int) []string {
+	rng := rand.New(rand.NewSource(42))
+	stems := []string{"inter", "trans", "micro", "under", "over", "re", "pre", "un", "de", "con"}
+	suffixes := []string{"", "s", "ed", "ing", "ation", "able", "ness", "ly"}
+	words := make([]string, n)
+	for i := range words {
+		b := []byte(stems[rng.Intn(len(stems))])
+		for j := 3 + rng.Intn(6); j > 0; j-- {
+			b = append(b, byte('a'+rng.Intn(26)))
+		}
+		words[i] = string(b) + suffixes[rng.Intn(len(suffixes))]
+	}
+	return words
+}
+
+// heapBytes reports the live heap growth caused by build. It is signed:
+// other garbage freed by the second collection can make it negative.
+func heapBytes(build func() any) float64 {
+	var before, after runtime.MemStats
+	runtime.GC()
+	runtime.ReadMemStats(&before)
+	keep := build()
+	runtime.GC()
+	runtime.ReadMemStats(&after)
+	runtime.KeepAlive(keep)
+	return float64(int64(after.HeapAlloc) - int64(before.HeapAlloc))
+}
+
+func BenchmarkTrieBuild(b *testing.B) {
+	words := benchmarkDictionary(100_000)
+	b.ReportMetric(heapBytes(func() any {
+		trie := InitTrie()
+		for _, w := range words {
+			trie.Insert(w)
+		}
+		return trie
+	})/float64(len(words)), "heap-B/word")
+
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		trie := InitTrie()
+		for _, w := range words {
+			trie.Insert(w)
+		}
+	}
+}
+
+func BenchmarkRadixTreeBuild(b *testing.B) {
+	words := benchmarkDictionary(100_000)
+	b.ReportMetric(heapBytes(func() any {
+		tree := NewRadixTree()
+		for _, w := range words {
+			tree.Insert(w)
+		}
+		return tree
+	})/float64(len(words)), "heap-B/word")
+
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		tree := NewRadixTree()
+		for _, w := range words {
+			tree.Insert(w)
+		}
+	}
+}
+
+func BenchmarkTrieSearch(b *testing.B) {
+	words := benchmarkDictionary(100_000)
+	trie := InitTrie()
+	for _, w := range words {
+		trie.Insert(w)
+	}
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		trie.Search(words[i%len(words)])
+	}
+}
+
+func BenchmarkRadixTreeSearch(b *testing.B) {
+	words := benchmarkDictionary(100_000)
+	tree := NewRadixTree()
+	for _, w := range words {
+		tree.Insert(w)
+	}
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		tree.Search(words[i%len(words)])
+	}
+}
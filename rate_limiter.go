@@ -2,51 +2,162 @@ package main
 
 import (
 	"fmt"
+	"math"
 	"net/http"
 	"sync"
 	"time"
 )
 
+// Limiter is implemented by every rate limiting algorithm in this package.
+type Limiter interface {
+	AllowRequest() bool
+}
+
+// RateLimitResult describes the outcome of a single rate limit check in the
+// terms used by the RateLimit-* response headers.
+type RateLimitResult struct {
+	Allowed    bool
+	Limit      int
+	Remaining  int
+	Reset      time.Duration // until the limiter is back at full capacity
+	RetryAfter time.Duration // until a rejected request could succeed
+}
+
+// ReportingLimiter is implemented by limiters that can describe their state
+// alongside the allow/deny decision.
+type ReportingLimiter interface {
+	Limiter
+	Take() RateLimitResult
+}
+
+// Clock abstracts time.Now so limiters can be driven by a fake clock in tests.
+type Clock interface {
+	Now() time.Time
+}
+
+type realClock struct{}
+
+func (realClock) Now() time.Time {
+	return time.Now()
+}
+
+// RateLimiter is a token bucket. Tokens are not added by a background
+// goroutine; instead the bucket is topped up from the elapsed time every
+// time it is consulted, which keeps fractional and very high rates exact.
 type RateLimiter struct {
-	tokens     int
-	maxTokens  int
-	rate       time.Duration
+	tokens    float64
+	maxTokens float64
+	rate      float64 // tokens per second
+	last      time.Time
+	clock     Clock
 	mutex     sync.Mutex
 }
 
 func NewRateLimiter(maxTokens int, ratePerSecond int) *RateLimiter {
-	rl := &RateLimiter{
-		tokens:    maxTokens,
-		maxTokens: maxTokens,
-		rate:      time.Second / time.Duration(ratePerSecond),
+	return NewTokenBucket(float64(ratePerSecond), maxTokens, realClock{})
+}
+
+// NewTokenBucket creates a bucket that refills at ratePerSecond (which may be
+// fractional, e.g. 0.5 for one token every two seconds) and holds at most
+// burst tokens. The bucket starts full. A nil clock uses the wall clock. It
+// panics if the rate is not positive.
+func NewTokenBucket(ratePerSecond float64, burst int, clock Clock) *RateLimiter {
+	checkRate("NewTokenBucket", ratePerSecond)
+	if clock == nil {
+		clock = realClock{}
 	}
-	go rl.refill()
-	return rl
-}
-
-func (rl *RateLimiter) refill() {
-	ticker := time.NewTicker(rl.rate)
-	defer ticker.Stop()
-	for {
-		<-ticker.C
-		rl.mutex.Lock()
-		if rl.tokens < rl.maxTokens {
-			rl.tokens++
-		}
-		rl.mutex.Unlock()
+	return &RateLimiter{
+		tokens:    float64(burst),
+		maxTokens: float64(burst),
+		rate:      ratePerSecond,
+		last:      clock.Now(),
+		clock:     clock,
+	}
+}
+
+// refill must be called with rl.mutex held.
+func (rl *RateLimiter) refill(now time.Time) {
+	elapsed := now.Sub(rl.last)
+	if elapsed <= 0 {
+		return
+	}
+	rl.last = now
+	rl.tokens += elapsed.Seconds() * rl.rate
+	if rl.tokens > rl.maxTokens {
+		rl.tokens = rl.maxTokens
 	}
 }
 
 func (rl *RateLimiter) AllowRequest() bool {
+	return rl.AllowN(1)
+}
+
+// AllowN reports whether n tokens are available and, if so, consumes them.
+func (rl *RateLimiter) AllowN(n int) bool {
 	rl.mutex.Lock()
 	defer rl.mutex.Unlock()
-	if rl.tokens > 0 {
-		rl.tokens--
+	rl.refill(rl.clock.Now())
+	if rl.tokens >= float64(n) {
+		rl.tokens -= float64(n)
 		return true
 	}
 	return false
 }
 
+// Take consumes a token if one is available and reports the bucket state.
+func (rl *RateLimiter) Take() RateLimitResult {
+	rl.mutex.Lock()
+	defer rl.mutex.Unlock()
+	rl.refill(rl.clock.Now())
+
+	res := RateLimitResult{Limit: int(rl.maxTokens)}
+	if rl.tokens >= 1 {
+		rl.tokens--
+		res.Allowed = true
+	} else {
+		res.RetryAfter = rl.timeToFill(1 - rl.tokens)
+	}
+	res.Remaining = int(rl.tokens)
+	res.Reset = rl.timeToFill(rl.maxTokens - rl.tokens)
+	return res
+}
+
+// checkRate panics unless ratePerSecond is positive and finite. A zero rate
+// never refills, so retry times would be infinite, and NaN compares false
+// against everything.
+func checkRate(constructor string, ratePerSecond float64) {
+	if !(ratePerSecond > 0) || math.IsInf(ratePerSecond, 1) {
+		panic(fmt.Sprintf("%s: rate must be positive and finite, got %v", constructor, ratePerSecond))
+	}
+}
+
+// checkWindow panics unless window is positive and limit is not negative. A
+// zero window would expire every request as soon as it was counted.
+func checkWindow(constructor string, limit int, window time.Duration) {
+	if window <= 0 {
+		panic(fmt.Sprintf("%s: window must be positive, got %v", constructor, window))
+	}
+	if limit < 0 {
+		panic(fmt.Sprintf("%s: limit must not be negative, got %d", constructor, limit))
+	}
+}
+
+// timeToFill returns how long it takes to accumulate n more tokens.
+func (rl *RateLimiter) timeToFill(n float64) time.Duration {
+	if n <= 0 {
+		return 0
+	}
+	return time.Duration(n / rl.rate * float64(time.Second))
+}
+
+// Tokens returns the number of tokens currently available.
+func (rl *RateLimiter) Tokens() float64 {
+	rl.mutex.Lock()
+	defer rl.mutex.Unlock()
+	rl.refill(rl.clock.Now())
+	return rl.tokens
+}
+
 func rateLimitedHandler(rl *RateLimiter) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		if rl.AllowRequest() {
@@ -60,7 +171,7 @@ func rateLimitedHandler(rl *RateLimiter) http.HandlerFunc {
 }
 
 func main() {
-	rlimiter := NewRateLimiter(5, 2) 
+	rlimiter := NewRateLimiter(5, 2)
 	http.HandleFunc("/api", rateLimitedHandler(rlimiter))
 	fmt.Println("Server is running on :8080")
 	http.ListenAndServe(":8080", nil)
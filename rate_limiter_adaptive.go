@@ -0,0 +1,274 @@
+package main
+
+import (
+	"context"
+	"math"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// LimitAlgorithm derives a new concurrency limit from one completed request.
+// Implementations are called with the limiter's lock held and need no
+// synchronization of their own.
+type LimitAlgorithm interface {
+	Update(limit float64, rtt time.Duration, inflight int, dropped bool) float64
+}
+
+// AIMD grows the limit additively while requests succeed and shrinks it
+// multiplicatively when one is dropped or slower than Timeout.
+type AIMD struct {
+	Increase float64       // added per successful sample, default 1
+	Backoff  float64       // applied on drop, default 0.9
+	Timeout  time.Duration // latency treated as a drop, zero to disable
+	Min, Max float64
+}
+
+func (a *AIMD) Update(limit float64, rtt time.Duration, inflight int, dropped bool) float64 {
+	if dropped || (a.Timeout > 0 && rtt > a.Timeout) {
+		backoff := a.Backoff
+		if backoff == 0 {
+			backoff = 0.9
+		}
+		return clampLimit(limit*backoff, a.Min, a.Max)
+	}
+	// Only grow while the limit is actually being used, otherwise an idle
+	// service would ratchet its limit up without ever testing it.
+	if float64(inflight)*2 < limit {
+		return limit
+	}
+	increase := a.Increase
+	if increase == 0 {
+		increase = 1
+	}
+	return clampLimit(limit+increase, a.Min, a.Max)
+}
+
+// Gradient compares each sample's latency with the lowest latency seen
+// recently, which approximates the service's no-load latency. When requests
+// queue the ratio drops below one and the limit shrinks in proportion; when
+// latency is at the floor the limit grows by roughly sqrt(limit), the queue
+// Vegas-style algorithms allow for.
+type Gradient struct {
+	Smoothing     float64 // weight of each new estimate, default 0.2
+	Tolerance     float64 // latency ratio tolerated before backing off, default 1.5
+	ProbeInterval int     // samples between minimum latency resets, default 1000
+	Min, Max      float64
+
+	minRTT  time.Duration
+	samples int
+}
+
+func (g *Gradient) Update(limit float64, rtt time.Duration, inflight int, dropped bool) float64 {
+	probe := g.ProbeInterval
+	if probe == 0 {
+		probe = 1000
+	}
+	g.samples++
+	if g.samples >= probe {
+		g.samples = 0
+		g.minRTT = 0
+	}
+	if g.minRTT == 0 || rtt < g.minRTT {
+		g.minRTT = rtt
+	}
+
+	smoothing := g.Smoothing
+	if smoothing == 0 {
+		smoothing = 0.2
+	}
+	tolerance := g.Tolerance
+	if tolerance == 0 {
+		tolerance = 1.5
+	}
+
+	gradient := 0.5
+	if !dropped && rtt > 0 {
+		gradient = math.Max(0.5, math.Min(1, tolerance*float64(g.minRTT)/float64(rtt)))
+	}
+	target := limit*gradient + math.Sqrt(limit)
+	if float64(inflight)*2 < limit && target > limit {
+		return limit
+	}
+	return clampLimit((1-smoothing)*limit+smoothing*target, g.Min, g.Max)
+}
+
+func clampLimit(limit, lo, hi float64) float64 {
+	if lo < 1 {
+		lo = 1
+	}
+	if limit < lo {
+		return lo
+	}
+	if hi > 0 && limit > hi {
+		return hi
+	}
+	return limit
+}
+
+// AdaptiveLimiter bounds the number of requests in flight at a limit that a
+// LimitAlgorithm adjusts from observed latency, instead of a fixed rate that
+// has to be tuned by hand.
+type AdaptiveLimiter struct {
+	algorithm LimitAlgorithm
+	limit     float64
+	inflight  int
+	released  chan struct{} // closed and replaced whenever a slot frees up
+	clock     Clock
+	mutex     sync.Mutex
+}
+
+// NewAdaptiveLimiter starts at initialLimit, raised to 1 if lower: the limit
+// only moves as requests complete, so a limiter that admits nothing would
+// never recover.
+func NewAdaptiveLimiter(initialLimit int, algorithm LimitAlgorithm) *AdaptiveLimiter {
+	return &AdaptiveLimiter{
+		algorithm: algorithm,
+		limit:     float64(max(initialLimit, 1)),
+		released:  make(chan struct{}),
+		clock:     realClock{},
+	}
+}
+
+// Limit returns the current concurrency limit.
+func (al *AdaptiveLimiter) Limit() int {
+	al.mutex.Lock()
+	defer al.mutex.Unlock()
+	return int(al.limit)
+}
+
+// Inflight returns the number of outstanding slots.
+func (al *AdaptiveLimiter) Inflight() int {
+	al.mutex.Lock()
+	defer al.mutex.Unlock()
+	return al.inflight
+}
+
+// Acquire takes a slot if one is free. The caller must finish it with
+// Success, Dropped or Ignore.
+func (al *AdaptiveLimiter) Acquire() (*AdaptiveSlot, bool) {
+	al.mutex.Lock()
+	defer al.mutex.Unlock()
+	if al.inflight >= int(al.limit) {
+		return nil, false
+	}
+	al.inflight++
+	return &AdaptiveSlot{limiter: al, start: al.clock.Now()}, true
+}
+
+// Wait blocks until a slot is free or ctx is done.
+func (al *AdaptiveLimiter) Wait(ctx context.Context) (*AdaptiveSlot, error) {
+	for {
+		al.mutex.Lock()
+		if al.inflight < int(al.limit) {
+			al.inflight++
+			slot := &AdaptiveSlot{limiter: al, start: al.clock.Now()}
+			al.mutex.Unlock()
+			return slot, nil
+		}
+		released := al.released
+		al.mutex.Unlock()
+
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-released:
+		}
+	}
+}
+
+func (al *AdaptiveLimiter) release(start time.Time, sample, dropped bool) {
+	al.mutex.Lock()
+	defer al.mutex.Unlock()
+	if sample {
+		rtt := al.clock.Now().Sub(start)
+		al.limit = al.algorithm.Update(al.limit, rtt, al.inflight, dropped)
+	}
+	al.inflight--
+	close(al.released)
+	al.released = make(chan struct{})
+}
+
+// AdaptiveSlot is one in-flight request admitted by an AdaptiveLimiter.
+type AdaptiveSlot struct {
+	limiter *AdaptiveLimiter
+	start   time.Time
+	once    sync.Once
+}
+
+// Success releases the slot and feeds its latency to the algorithm.
+func (s *AdaptiveSlot) Success() {
+	s.once.Do(func() { s.limiter.release(s.start, true, false) })
+}
+
+// Dropped releases the slot and reports the request as a sign of overload,
+// e.g. a timeout or a 503 from a dependency.
+func (s *AdaptiveSlot) Dropped() {
+	s.once.Do(func() { s.limiter.release(s.start, true, true) })
+}
+
+// Ignore releases the slot without affecting the limit, for requests whose
+// latency says nothing about load such as client errors.
+func (s *AdaptiveSlot) Ignore() {
+	s.once.Do(func() { s.limiter.release(s.start, false, false) })
+}
+
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (r *statusRecorder) WriteHeader(status int) {
+	r.status = status
+	r.ResponseWriter.WriteHeader(status)
+}
+
+// Flush passes through to the underlying writer so that streaming handlers
+// keep working behind the middleware.
+func (r *statusRecorder) Flush() {
+	if f, ok := r.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer.
+func (r *statusRecorder) Unwrap() http.ResponseWriter {
+	return r.ResponseWriter
+}
+
+// AdaptiveMiddleware answers 503 Service Unavailable once the adaptive limit
+// of concurrent requests is reached. Responses of 503 or 504 from the wrapped
+// handler count as drops, other 5xx and 4xx responses are ignored. A handler
+// that panics also counts as a drop; its slot is released before the panic
+// carries on up to the server.
+func AdaptiveMiddleware(al *AdaptiveLimiter) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			slot, ok := al.Acquire()
+			if !ok {
+				http.Error(w, "Server is overloaded. Try again later.", http.StatusServiceUnavailable)
+				return
+			}
+
+			completed := false
+			defer func() {
+				if !completed {
+					slot.Dropped()
+				}
+			}()
+
+			rec := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+			next.ServeHTTP(rec, r)
+			completed = true
+
+			switch {
+			case rec.status == http.StatusServiceUnavailable || rec.status == http.StatusGatewayTimeout:
+				slot.Dropped()
+			case rec.status >= 400:
+				slot.Ignore()
+			default:
+				slot.Success()
+			}
+		})
+	}
+}
@@ -0,0 +1,129 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestLimitAlgorithms(t *testing.T) {
+	tests := []struct {
+		name      string
+		algorithm LimitAlgorithm
+		rtt       time.Duration
+		dropped   bool
+		wantGrow  bool
+	}{
+		{name: "aimd success", algorithm: &AIMD{}, rtt: 10 * time.Millisecond, wantGrow: true},
+		{name: "aimd drop", algorithm: &AIMD{}, rtt: 10 * time.Millisecond, dropped: true},
+		{name: "aimd timeout", algorithm: &AIMD{Timeout: 50 * time.Millisecond}, rtt: 100 * time.Millisecond},
+		{name: "gradient at min latency", algorithm: &Gradient{}, rtt: 10 * time.Millisecond, wantGrow: true},
+		{name: "gradient queueing", algorithm: &Gradient{}, rtt: 50 * time.Millisecond},
+		{name: "gradient drop", algorithm: &Gradient{}, rtt: 10 * time.Millisecond, dropped: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// Establish the no-load latency first.
+			limit := tt.algorithm.Update(20, 10*time.Millisecond, 20, false)
+			got := tt.algorithm.Update(limit, tt.rtt, 20, tt.dropped)
+			if grew := got > limit; grew != tt.wantGrow {
+				t.Errorf("Update(%v) = %v from %v, want grow = %v", tt.rtt, got, limit, tt.wantGrow)
+			}
+		})
+	}
+}
+
+func TestAdaptiveLimiterBoundsInflight(t *testing.T) {
+	al := NewAdaptiveLimiter(2, &AIMD{Max: 2})
+
+	a, ok := al.Acquire()
+	if !ok {
+		t.Fatal("first Acquire() failed")
+	}
+	if _, ok := al.Acquire(); !ok {
+		t.Fatal("second Acquire() failed")
+	}
+	if _, ok := al.Acquire(); ok {
+		t.Fatal("Acquire() over the limit succeeded")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	waited := make(chan error)
+	go func() {
+		_, err := al.Wait(ctx)
+		waited <- err
+	}()
+	a.Success()
+	if err := <-waited; err != nil {
+		t.Errorf("Wait() after release = %v, want nil", err)
+	}
+	if got := al.Inflight(); got != 2 {
+		t.Errorf("Inflight() = %d, want 2", got)
+	}
+}
+
+func TestAdaptiveLimiterRaisesZeroInitialLimit(t *testing.T) {
+	for _, initial := range []int{0, -3} {
+		al := NewAdaptiveLimiter(initial, &AIMD{})
+		if got := al.Limit(); got != 1 {
+			t.Errorf("NewAdaptiveLimiter(%d).Limit() = %d, want 1", initial, got)
+		}
+		slot, ok := al.Acquire()
+		if !ok {
+			t.Fatalf("Acquire() with initial limit %d failed", initial)
+		}
+		slot.Success()
+	}
+}
+
+func TestAdaptiveLimiterWaitHonoursContext(t *testing.T) {
+	al := NewAdaptiveLimiter(1, &AIMD{})
+	al.Acquire()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if _, err := al.Wait(ctx); err != context.DeadlineExceeded {
+		t.Errorf("Wait() = %v, want %v", err, context.DeadlineExceeded)
+	}
+}
+
+func TestAdaptiveMiddlewareReleasesSlotOnPanic(t *testing.T) {
+	al := NewAdaptiveLimiter(1, &AIMD{Max: 1})
+	handler := AdaptiveMiddleware(al)(http.HandlerFunc(func(http.ResponseWriter, *http.Request) {
+		panic("handler failed")
+	}))
+
+	func() {
+		defer func() {
+			if recover() == nil {
+				t.Error("panic was swallowed by the middleware")
+			}
+		}()
+		handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/", nil))
+	}()
+	if got := al.Inflight(); got != 0 {
+		t.Errorf("Inflight() after panic = %d, want 0", got)
+	}
+	if _, ok := al.Acquire(); !ok {
+		t.Error("Acquire() failed after a panicking request")
+	}
+}
+
+func TestAdaptiveMiddlewareFlushes(t *testing.T) {
+	al := NewAdaptiveLimiter(1, &AIMD{Max: 1})
+	handler := AdaptiveMiddleware(al)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err := http.NewResponseController(w).Flush(); err != nil {
+			t.Errorf("Flush() = %v", err)
+		}
+	}))
+
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest("GET", "/", nil))
+	if !rec.Flushed {
+		t.Error("response was not flushed through the middleware")
+	}
+}
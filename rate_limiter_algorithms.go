@@ -0,0 +1,292 @@
+package main
+
+import (
+	"math"
+	"sync"
+	"time"
+)
+
+// SlidingWindowLog remembers the timestamp of every accepted request and
+// allows a new one only while fewer than limit fall inside the trailing
+// window. It is exact but uses memory proportional to limit.
+type SlidingWindowLog struct {
+	limit  int
+	window time.Duration
+	log    []time.Time
+	clock  Clock
+	mutex  sync.Mutex
+}
+
+// NewSlidingWindowLog panics if window is not positive or limit is negative.
+func NewSlidingWindowLog(limit int, window time.Duration, clock Clock) *SlidingWindowLog {
+	checkWindow("NewSlidingWindowLog", limit, window)
+	if clock == nil {
+		clock = realClock{}
+	}
+	return &SlidingWindowLog{
+		limit:  limit,
+		window: window,
+		log:    make([]time.Time, 0, limit),
+		clock:  clock,
+	}
+}
+
+func (l *SlidingWindowLog) AllowRequest() bool {
+	return l.Take().Allowed
+}
+
+func (l *SlidingWindowLog) Take() RateLimitResult {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	now := l.clock.Now()
+	expired := 0
+	for expired < len(l.log) && now.Sub(l.log[expired]) >= l.window {
+		expired++
+	}
+	l.log = append(l.log[:0], l.log[expired:]...)
+
+	res := RateLimitResult{Limit: l.limit}
+	if len(l.log) >= l.limit {
+		// A request fits once the entry that takes the log below limit
+		// leaves the window.
+		if l.limit > 0 {
+			res.RetryAfter = l.log[len(l.log)-l.limit].Add(l.window).Sub(now)
+		}
+	} else {
+		res.Allowed = true
+		l.log = append(l.log, now)
+	}
+	res.Remaining = max(l.limit-len(l.log), 0)
+	if len(l.log) > 0 {
+		res.Reset = l.log[len(l.log)-1].Add(l.window).Sub(now)
+	}
+	return res
+}
+
+// SlidingWindowCounter approximates a sliding window using the counts of the
+// current and previous fixed windows, weighting the previous one by how much
+// of it still overlaps the trailing window.
+type SlidingWindowCounter struct {
+	limit       int
+	window      time.Duration
+	windowStart time.Time
+	current     int
+	previous    int
+	clock       Clock
+	mutex       sync.Mutex
+}
+
+// NewSlidingWindowCounter panics if window is not positive or limit is
+// negative.
+func NewSlidingWindowCounter(limit int, window time.Duration, clock Clock) *SlidingWindowCounter {
+	checkWindow("NewSlidingWindowCounter", limit, window)
+	if clock == nil {
+		clock = realClock{}
+	}
+	return &SlidingWindowCounter{
+		limit:       limit,
+		window:      window,
+		windowStart: clock.Now().Truncate(window),
+		clock:       clock,
+	}
+}
+
+func (l *SlidingWindowCounter) AllowRequest() bool {
+	return l.Take().Allowed
+}
+
+func (l *SlidingWindowCounter) Take() RateLimitResult {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	now := l.clock.Now()
+	start := now.Truncate(l.window)
+	switch elapsed := start.Sub(l.windowStart); {
+	case elapsed == l.window:
+		l.previous, l.current = l.current, 0
+	case elapsed > l.window:
+		l.previous, l.current = 0, 0
+	}
+	l.windowStart = start
+
+	weight := 1 - float64(now.Sub(start))/float64(l.window)
+	estimate := float64(l.previous)*weight + float64(l.current)
+	res := RateLimitResult{Limit: l.limit}
+	if estimate >= float64(l.limit) {
+		res.RetryAfter = l.retryAfter(now, start)
+	} else {
+		res.Allowed = true
+		l.current++
+		estimate++
+	}
+	res.Remaining = max(l.limit-int(math.Ceil(estimate)), 0)
+	switch {
+	case l.current > 0:
+		res.Reset = start.Add(2 * l.window).Sub(now)
+	case l.previous > 0:
+		res.Reset = start.Add(l.window).Sub(now)
+	}
+	return res
+}
+
+// retryAfter returns how long until the weighted count drops below limit.
+// The previous window's share shrinks linearly over the current window; if
+// the current window alone is at the limit, its own share has to shrink over
+// the next one.
+func (l *SlidingWindowCounter) retryAfter(now, start time.Time) time.Duration {
+	if l.limit <= 0 {
+		return 0
+	}
+	from, count, room := start, l.previous, l.limit-l.current
+	if room <= 0 {
+		from, count, room = start.Add(l.window), l.current, l.limit
+	}
+	// count*(1-f) < room once f exceeds 1-room/count.
+	f := 1 - float64(room)/float64(count)
+	at := from.Add(time.Duration(f*float64(l.window)) + 1)
+	return max(at.Sub(now), 0)
+}
+
+// emissionInterval returns the time between requests at ratePerSecond. The
+// interval-based limiters divide by it, so it must not round down to zero.
+func emissionInterval(constructor string, ratePerSecond float64) time.Duration {
+	checkRate(constructor, ratePerSecond)
+	interval := time.Duration(float64(time.Second) / ratePerSecond)
+	if interval <= 0 {
+		panic(constructor + ": rate exceeds one request per nanosecond")
+	}
+	return interval
+}
+
+// GCRA is the generic cell rate algorithm. It stores a single theoretical
+// arrival time and behaves like a token bucket of the same rate and burst,
+// which makes it cheap to keep in a shared store.
+type GCRA struct {
+	interval time.Duration // emission interval between requests
+	burst    int
+	tat      time.Time
+	clock    Clock
+	mutex    sync.Mutex
+}
+
+// NewGCRA panics if the rate is not positive or exceeds one request per
+// nanosecond.
+func NewGCRA(ratePerSecond float64, burst int, clock Clock) *GCRA {
+	interval := emissionInterval("NewGCRA", ratePerSecond)
+	if clock == nil {
+		clock = realClock{}
+	}
+	return &GCRA{
+		interval: interval,
+		burst:    burst,
+		clock:    clock,
+	}
+}
+
+func (g *GCRA) AllowRequest() bool {
+	return g.Take().Allowed
+}
+
+func (g *GCRA) Take() RateLimitResult {
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+
+	now := g.clock.Now()
+	tat := g.tat
+	if tat.Before(now) {
+		tat = now
+	}
+	newTat := tat.Add(g.interval)
+	window := g.interval * time.Duration(g.burst)
+
+	res := RateLimitResult{Limit: g.burst}
+	if over := newTat.Sub(now) - window; over > 0 {
+		res.RetryAfter = over
+	} else {
+		res.Allowed = true
+		g.tat = newTat
+		tat = newTat
+	}
+	res.Remaining = int((window - tat.Sub(now)) / g.interval)
+	res.Reset = tat.Sub(now)
+	return res
+}
+
+// LeakyBucket queues requests and lets them out at a constant rate. A request
+// is rejected only when the queue is already holding capacity requests;
+// otherwise it is admitted and delayed until its slot comes up.
+type LeakyBucket struct {
+	capacity int
+	interval time.Duration
+	next     time.Time
+	clock    Clock
+	sleep    func(time.Duration)
+	mutex    sync.Mutex
+}
+
+// NewLeakyBucket panics if the rate is not positive or exceeds one request
+// per nanosecond.
+func NewLeakyBucket(capacity int, ratePerSecond float64, clock Clock) *LeakyBucket {
+	interval := emissionInterval("NewLeakyBucket", ratePerSecond)
+	if clock == nil {
+		clock = realClock{}
+	}
+	return &LeakyBucket{
+		capacity: capacity,
+		interval: interval,
+		clock:    clock,
+		sleep:    time.Sleep,
+	}
+}
+
+// Reserve claims the next slot in the queue and returns how long the caller
+// must wait before proceeding. ok is false if the queue is full.
+func (lb *LeakyBucket) Reserve() (delay time.Duration, ok bool) {
+	delay, res := lb.reserve()
+	return delay, res.Allowed
+}
+
+func (lb *LeakyBucket) reserve() (time.Duration, RateLimitResult) {
+	lb.mutex.Lock()
+	defer lb.mutex.Unlock()
+
+	now := lb.clock.Now()
+	next := lb.next
+	if next.Before(now) {
+		next = now
+	}
+	delay := next.Sub(now)
+	res := RateLimitResult{Limit: lb.capacity, Reset: delay}
+	queued := int(delay / lb.interval)
+	if queued >= lb.capacity {
+		// The queue has room again once its backlog has leaked below
+		// capacity intervals.
+		if lb.capacity > 0 {
+			res.RetryAfter = delay - time.Duration(lb.capacity)*lb.interval + 1
+		}
+		return 0, res
+	}
+	lb.next = next.Add(lb.interval)
+	res.Allowed = true
+	res.Remaining = lb.capacity - queued - 1
+	res.Reset = lb.next.Sub(now)
+	return delay, res
+}
+
+// AllowRequest blocks until the request leaves the queue, or returns false
+// straight away if the queue is full.
+func (lb *LeakyBucket) AllowRequest() bool {
+	return lb.Take().Allowed
+}
+
+// Take is AllowRequest with the queue's state. An admitted request has
+// already waited for its slot when Take returns.
+func (lb *LeakyBucket) Take() RateLimitResult {
+	delay, res := lb.reserve()
+	if res.Allowed && delay > 0 {
+		lb.sleep(delay)
+		res.Reset -= delay
+	}
+	return res
+}
@@ -0,0 +1,238 @@
+package main
+
+import (
+	"math"
+	"testing"
+	"time"
+)
+
+// TestLimiterBurstBehavior runs the same traffic pattern against every
+// algorithm configured for 5 requests per second: a burst of 10 at t=0,
+// another 10 at t=500ms, 10 at t=1s and 10 at t=1.5s.
+func TestLimiterBurstBehavior(t *testing.T) {
+	type step struct {
+		advance  time.Duration
+		attempts int
+		want     int
+	}
+
+	tests := []struct {
+		name  string
+		new   func(Clock) Limiter
+		steps []step
+	}{
+		{
+			name: "token bucket",
+			new:  func(c Clock) Limiter { return NewTokenBucket(5, 5, c) },
+			steps: []step{
+				{0, 10, 5},
+				{500 * time.Millisecond, 10, 2},
+				{500 * time.Millisecond, 10, 3},
+				{500 * time.Millisecond, 10, 2},
+			},
+		},
+		{
+			name: "sliding window log",
+			new:  func(c Clock) Limiter { return NewSlidingWindowLog(5, time.Second, c) },
+			steps: []step{
+				{0, 10, 5},
+				{500 * time.Millisecond, 10, 0},
+				{500 * time.Millisecond, 10, 5},
+				{500 * time.Millisecond, 10, 0},
+			},
+		},
+		{
+			name: "sliding window counter",
+			new:  func(c Clock) Limiter { return NewSlidingWindowCounter(5, time.Second, c) },
+			steps: []step{
+				{0, 10, 5},
+				{500 * time.Millisecond, 10, 0},
+				{500 * time.Millisecond, 10, 0},
+				{500 * time.Millisecond, 10, 3},
+			},
+		},
+		{
+			name: "gcra",
+			new:  func(c Clock) Limiter { return NewGCRA(5, 5, c) },
+			steps: []step{
+				{0, 10, 5},
+				{500 * time.Millisecond, 10, 2},
+				{500 * time.Millisecond, 10, 3},
+				{500 * time.Millisecond, 10, 2},
+			},
+		},
+		{
+			name: "leaky bucket",
+			new: func(c Clock) Limiter {
+				lb := NewLeakyBucket(5, 5, c)
+				lb.sleep = func(time.Duration) {}
+				return lb
+			},
+			steps: []step{
+				{0, 10, 5},
+				{500 * time.Millisecond, 10, 3},
+				{500 * time.Millisecond, 10, 2},
+				{500 * time.Millisecond, 10, 3},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			clock := newFakeClock()
+			limiter := tt.new(clock)
+			for i, s := range tt.steps {
+				clock.Advance(s.advance)
+				got := 0
+				for j := 0; j < s.attempts; j++ {
+					if limiter.AllowRequest() {
+						got++
+					}
+				}
+				if got != s.want {
+					t.Errorf("step %d: allowed %d of %d, want %d", i, got, s.attempts, s.want)
+				}
+			}
+		})
+	}
+}
+
+// TestLimiterTakeReportsRetryAfter checks that a rejection says how long
+// until a request fits again, and that it does fit once that time is up.
+func TestLimiterTakeReportsRetryAfter(t *testing.T) {
+	tests := []struct {
+		name      string
+		new       func(Clock) ReportingLimiter
+		gaps      []time.Duration // clock advance before each admitted request
+		wantRetry time.Duration
+	}{
+		{
+			name:      "sliding window log",
+			new:       func(c Clock) ReportingLimiter { return NewSlidingWindowLog(2, 10*time.Second, c) },
+			gaps:      []time.Duration{0, 3 * time.Second, time.Second},
+			wantRetry: 6 * time.Second, // the first entry leaves at 10s
+		},
+		{
+			name:      "sliding window counter",
+			new:       func(c Clock) ReportingLimiter { return NewSlidingWindowCounter(2, 10*time.Second, c) },
+			gaps:      []time.Duration{0, 0, 0},
+			wantRetry: 10*time.Second + 1, // just into the next window
+		},
+		{
+			name: "leaky bucket",
+			new: func(c Clock) ReportingLimiter {
+				lb := NewLeakyBucket(2, 10, c)
+				lb.sleep = func(time.Duration) {}
+				return lb
+			},
+			gaps:      []time.Duration{0, 0, 0},
+			wantRetry: 1, // the backlog drops below two slots straight away
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			clock := newFakeClock()
+			l := tt.new(clock)
+			for i, gap := range tt.gaps[:len(tt.gaps)-1] {
+				clock.Advance(gap)
+				if res := l.Take(); !res.Allowed || res.Limit != 2 || res.Remaining != 1-i {
+					t.Fatalf("Take() #%d = %+v, want allowed with limit 2 and %d remaining", i, res, 1-i)
+				}
+			}
+			clock.Advance(tt.gaps[len(tt.gaps)-1])
+			res := l.Take()
+			if res.Allowed || res.RetryAfter != tt.wantRetry {
+				t.Fatalf("Take() over the limit = %+v, want rejected with RetryAfter %v", res, tt.wantRetry)
+			}
+			if res.Reset < res.RetryAfter {
+				t.Errorf("Reset %v is before RetryAfter %v", res.Reset, res.RetryAfter)
+			}
+			clock.Advance(res.RetryAfter)
+			if !l.Take().Allowed {
+				t.Error("Take() after RetryAfter was rejected")
+			}
+		})
+	}
+}
+
+func TestLeakyBucketSpacesRequests(t *testing.T) {
+	clock := newFakeClock()
+	lb := NewLeakyBucket(3, 10, clock)
+
+	want := []time.Duration{0, 100 * time.Millisecond, 200 * time.Millisecond}
+	for i, w := range want {
+		delay, ok := lb.Reserve()
+		if !ok || delay != w {
+			t.Errorf("Reserve() #%d = (%v, %v), want (%v, true)", i, delay, ok, w)
+		}
+	}
+	if _, ok := lb.Reserve(); ok {
+		t.Error("Reserve() on a full queue = true, want false")
+	}
+}
+
+func TestLimiterConstructorsRejectBadRates(t *testing.T) {
+	constructors := []struct {
+		name string
+		new  func(rate float64)
+	}{
+		{name: "token bucket", new: func(r float64) { NewTokenBucket(r, 1, nil) }},
+		{name: "gcra", new: func(r float64) { NewGCRA(r, 1, nil) }},
+		{name: "leaky bucket", new: func(r float64) { NewLeakyBucket(1, r, nil) }},
+	}
+	rates := []float64{0, -1, math.NaN(), math.Inf(1)}
+
+	for _, c := range constructors {
+		for _, rate := range rates {
+			func() {
+				defer func() {
+					if recover() == nil {
+						t.Errorf("%s with rate %v did not panic", c.name, rate)
+					}
+				}()
+				c.new(rate)
+			}()
+		}
+	}
+
+	// An interval of zero would divide by zero on every request.
+	for _, c := range constructors[1:] {
+		func() {
+			defer func() {
+				if recover() == nil {
+					t.Errorf("%s with a rate above 1e9/s did not panic", c.name)
+				}
+			}()
+			c.new(1e10)
+		}()
+	}
+
+	windowed := []struct {
+		name string
+		new  func(limit int, window time.Duration)
+	}{
+		{name: "sliding window log", new: func(l int, w time.Duration) { NewSlidingWindowLog(l, w, nil) }},
+		{name: "sliding window counter", new: func(l int, w time.Duration) { NewSlidingWindowCounter(l, w, nil) }},
+	}
+	bad := []struct {
+		limit  int
+		window time.Duration
+	}{
+		{limit: 1, window: 0},
+		{limit: 1, window: -time.Second},
+		{limit: -1, window: time.Second},
+	}
+	for _, c := range windowed {
+		for _, b := range bad {
+			func() {
+				defer func() {
+					if recover() == nil {
+						t.Errorf("%s with limit %d and window %v did not panic", c.name, b.limit, b.window)
+					}
+				}()
+				c.new(b.limit, b.window)
+			}()
+		}
+	}
+}
@@ -0,0 +1,227 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"time"
+)
+
+// CounterStore is the subset of a shared cache that distributed limiters
+// need. Implementations backed by a network cache must make Incr and
+// CompareAndSwap atomic across all replicas.
+type CounterStore interface {
+	// Incr adds delta to key and returns the new value. A missing key
+	// starts from zero and is created with the given ttl.
+	Incr(ctx context.Context, key string, delta int64, ttl time.Duration) (int64, error)
+	// Get returns the value at key, or zero if it is missing.
+	Get(ctx context.Context, key string) (int64, error)
+	// CompareAndSwap sets key to new if it currently holds old. A missing
+	// key holds zero.
+	CompareAndSwap(ctx context.Context, key string, old, new int64, ttl time.Duration) (bool, error)
+}
+
+// ExpiringMapStore adapts an ExpiringMap to CounterStore. It only shares
+// state between limiters in one process; serve it with CounterStoreHandler
+// and use HTTPCounterStore to share it between replicas.
+type ExpiringMapStore struct {
+	*ExpiringMap
+}
+
+func (s ExpiringMapStore) Incr(_ context.Context, key string, delta int64, ttl time.Duration) (int64, error) {
+	return s.ExpiringMap.Incr(key, delta, ttl)
+}
+
+func (s ExpiringMapStore) Get(_ context.Context, key string) (int64, error) {
+	v, ok := s.ExpiringMap.Get(key)
+	if !ok {
+		return 0, nil
+	}
+	n, ok := v.(int64)
+	if !ok {
+		return 0, fmt.Errorf("get %q: %w", key, ErrNotCounter)
+	}
+	return n, nil
+}
+
+func (s ExpiringMapStore) CompareAndSwap(_ context.Context, key string, old, new int64, ttl time.Duration) (bool, error) {
+	return s.ExpiringMap.CompareAndSwap(key, old, new, ttl)
+}
+
+// FailurePolicy decides what a distributed limiter does when its store is
+// unreachable.
+type FailurePolicy int
+
+const (
+	// FailOpen allows every request while the store is down.
+	FailOpen FailurePolicy = iota
+	// FailClosed rejects every request while the store is down.
+	FailClosed
+	// FailLocal falls back to a per-instance limiter.
+	FailLocal
+)
+
+// DistributedOptions configures the shared store and failure handling of a
+// distributed limiter.
+type DistributedOptions struct {
+	Store    CounterStore
+	Prefix   string        // prepended to every store key
+	Timeout  time.Duration // per store operation, zero for none
+	Policy   FailurePolicy
+	Fallback Limiter // used with FailLocal, which requires it
+	OnError  func(error)
+	Clock    Clock
+}
+
+// check panics on options that cannot work, rather than letting a missing
+// Fallback quietly turn FailLocal into FailClosed.
+func (o *DistributedOptions) check(constructor string) {
+	if o.Store == nil {
+		panic(constructor + ": nil Store")
+	}
+	if o.Policy == FailLocal && o.Fallback == nil {
+		panic(constructor + ": FailLocal requires a Fallback limiter")
+	}
+}
+
+func (o *DistributedOptions) context() (context.Context, context.CancelFunc) {
+	if o.Timeout > 0 {
+		return context.WithTimeout(context.Background(), o.Timeout)
+	}
+	return context.WithCancel(context.Background())
+}
+
+func (o *DistributedOptions) failed(err error) RateLimitResult {
+	if o.OnError != nil {
+		o.OnError(err)
+	}
+	switch o.Policy {
+	case FailOpen:
+		return RateLimitResult{Allowed: true}
+	case FailLocal:
+		return checkLimit(o.Fallback)
+	}
+	return RateLimitResult{}
+}
+
+// DistributedWindow counts requests per fixed window with an atomic
+// increment on a key that expires with the window.
+type DistributedWindow struct {
+	key    string
+	limit  int
+	window time.Duration
+	opts   DistributedOptions
+}
+
+// NewDistributedWindow panics if window is not positive or limit is
+// negative, as well as on invalid options.
+func NewDistributedWindow(key string, limit int, window time.Duration, opts DistributedOptions) *DistributedWindow {
+	opts.check("NewDistributedWindow")
+	checkWindow("NewDistributedWindow", limit, window)
+	if opts.Clock == nil {
+		opts.Clock = realClock{}
+	}
+	return &DistributedWindow{key: opts.Prefix + key, limit: limit, window: window, opts: opts}
+}
+
+func (d *DistributedWindow) AllowRequest() bool {
+	return d.Take().Allowed
+}
+
+func (d *DistributedWindow) Take() RateLimitResult {
+	now := d.opts.Clock.Now()
+	start := now.Truncate(d.window)
+	key := d.key + ":" + strconv.FormatInt(start.UnixNano(), 10)
+
+	ctx, cancel := d.opts.context()
+	defer cancel()
+	n, err := d.opts.Store.Incr(ctx, key, 1, d.window)
+	if err != nil {
+		return d.opts.failed(err)
+	}
+
+	reset := start.Add(d.window).Sub(now)
+	res := RateLimitResult{
+		Allowed:   n <= int64(d.limit),
+		Limit:     d.limit,
+		Remaining: max(d.limit-int(n), 0),
+		Reset:     reset,
+	}
+	if !res.Allowed {
+		res.RetryAfter = reset
+	}
+	return res
+}
+
+// maxCASAttempts bounds how often DistributedGCRA retries a lost race before
+// rejecting the request.
+const maxCASAttempts = 5
+
+// DistributedGCRA keeps the GCRA theoretical arrival time in the store and
+// updates it with compare-and-swap, giving token bucket semantics across
+// replicas with a single key per client.
+type DistributedGCRA struct {
+	key      string
+	interval time.Duration
+	burst    int
+	opts     DistributedOptions
+}
+
+func NewDistributedGCRA(key string, ratePerSecond float64, burst int, opts DistributedOptions) *DistributedGCRA {
+	opts.check("NewDistributedGCRA")
+	interval := emissionInterval("NewDistributedGCRA", ratePerSecond)
+	if opts.Clock == nil {
+		opts.Clock = realClock{}
+	}
+	return &DistributedGCRA{
+		key:      opts.Prefix + key,
+		interval: interval,
+		burst:    burst,
+		opts:     opts,
+	}
+}
+
+func (d *DistributedGCRA) AllowRequest() bool {
+	return d.Take().Allowed
+}
+
+func (d *DistributedGCRA) Take() RateLimitResult {
+	ctx, cancel := d.opts.context()
+	defer cancel()
+
+	window := d.interval * time.Duration(d.burst)
+	for attempt := 0; attempt < maxCASAttempts; attempt++ {
+		stored, err := d.opts.Store.Get(ctx, d.key)
+		if err != nil {
+			return d.opts.failed(err)
+		}
+
+		now := d.opts.Clock.Now()
+		tat := time.Unix(0, stored)
+		if tat.Before(now) {
+			tat = now
+		}
+		newTat := tat.Add(d.interval)
+		if over := newTat.Sub(now) - window; over > 0 {
+			return RateLimitResult{
+				Limit:      d.burst,
+				Reset:      tat.Sub(now),
+				RetryAfter: over,
+			}
+		}
+
+		swapped, err := d.opts.Store.CompareAndSwap(ctx, d.key, stored, newTat.UnixNano(), window)
+		if err != nil {
+			return d.opts.failed(err)
+		}
+		if swapped {
+			return RateLimitResult{
+				Allowed:   true,
+				Limit:     d.burst,
+				Remaining: int((window - newTat.Sub(now)) / d.interval),
+				Reset:     newTat.Sub(now),
+			}
+		}
+	}
+	return RateLimitResult{Limit: d.burst, RetryAfter: d.interval}
+}
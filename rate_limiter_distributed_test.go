@@ -0,0 +1,251 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+type downStore struct{}
+
+var errStoreDown = errors.New("store unreachable")
+
+func (downStore) Incr(context.Context, string, int64, time.Duration) (int64, error) {
+	return 0, errStoreDown
+}
+
+func (downStore) Get(context.Context, string) (int64, error) {
+	return 0, errStoreDown
+}
+
+func (downStore) CompareAndSwap(context.Context, string, int64, int64, time.Duration) (bool, error) {
+	return false, errStoreDown
+}
+
+// testCounterStore checks the CounterStore contract that the distributed
+// limiters rely on. A networked store should pass it unchanged.
+func testCounterStore(t *testing.T, store CounterStore) {
+	ctx := context.Background()
+
+	if v, err := store.Get(ctx, "missing"); err != nil || v != 0 {
+		t.Errorf("Get(missing) = %d, %v, want 0, nil", v, err)
+	}
+	if v, err := store.Incr(ctx, "n", 2, time.Minute); err != nil || v != 2 {
+		t.Errorf("Incr(n, 2) = %d, %v, want 2, nil", v, err)
+	}
+	if v, err := store.Incr(ctx, "n", 3, time.Minute); err != nil || v != 5 {
+		t.Errorf("Incr(n, 3) = %d, %v, want 5, nil", v, err)
+	}
+	if v, err := store.Get(ctx, "n"); err != nil || v != 5 {
+		t.Errorf("Get(n) = %d, %v, want 5, nil", v, err)
+	}
+
+	if ok, err := store.CompareAndSwap(ctx, "cas", 0, 7, time.Minute); err != nil || !ok {
+		t.Errorf("CompareAndSwap on missing key with old 0 = %v, %v, want true, nil", ok, err)
+	}
+	if ok, err := store.CompareAndSwap(ctx, "cas", 0, 9, time.Minute); err != nil || ok {
+		t.Errorf("CompareAndSwap with stale old = %v, %v, want false, nil", ok, err)
+	}
+	if v, _ := store.Get(ctx, "cas"); v != 7 {
+		t.Errorf("Get(cas) = %d, want 7", v)
+	}
+
+	store.Incr(ctx, "short", 1, 20*time.Millisecond)
+	time.Sleep(50 * time.Millisecond)
+	if v, err := store.Get(ctx, "short"); err != nil || v != 0 {
+		t.Errorf("Get after ttl = %d, %v, want 0, nil", v, err)
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			store.Incr(ctx, "concurrent", 1, time.Minute)
+		}()
+	}
+	wg.Wait()
+	if v, _ := store.Get(ctx, "concurrent"); v != 50 {
+		t.Errorf("50 concurrent increments gave %d", v)
+	}
+}
+
+func TestCounterStoreContract(t *testing.T) {
+	t.Run("expiring map", func(t *testing.T) {
+		em := NewExpiringMap(time.Minute)
+		defer em.Close()
+		testCounterStore(t, ExpiringMapStore{em})
+	})
+	t.Run("http", func(t *testing.T) {
+		em := NewExpiringMap(time.Minute)
+		defer em.Close()
+		srv := httptest.NewServer(CounterStoreHandler(ExpiringMapStore{em}))
+		defer srv.Close()
+		testCounterStore(t, HTTPCounterStore{URL: srv.URL})
+	})
+}
+
+func TestHTTPCounterStoreReportsErrors(t *testing.T) {
+	srv := httptest.NewServer(CounterStoreHandler(downStore{}))
+	defer srv.Close()
+	store := HTTPCounterStore{URL: srv.URL}
+	if _, err := store.Incr(context.Background(), "k", 1, time.Minute); err == nil {
+		t.Error("Incr against a failing backend returned no error")
+	}
+
+	srv.Close()
+	if _, err := store.Get(context.Background(), "k"); err == nil {
+		t.Error("Get against a closed server returned no error")
+	}
+}
+
+func TestDistributedLimitersShareState(t *testing.T) {
+	tests := []struct {
+		name string
+		new  func(DistributedOptions) Limiter
+	}{
+		{
+			name: "window",
+			new: func(o DistributedOptions) Limiter {
+				return NewDistributedWindow("client-1", 4, time.Minute, o)
+			},
+		},
+		{
+			name: "gcra",
+			new: func(o DistributedOptions) Limiter {
+				return NewDistributedGCRA("client-1", 1, 4, o)
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			em := NewExpiringMap(time.Minute)
+			defer em.Close()
+			srv := httptest.NewServer(CounterStoreHandler(ExpiringMapStore{em}))
+			defer srv.Close()
+
+			// Each replica talks to the shared store through its own client,
+			// as separate processes would.
+			clock := newFakeClock()
+			replicas := []Limiter{
+				tt.new(DistributedOptions{Store: HTTPCounterStore{URL: srv.URL}, Clock: clock}),
+				tt.new(DistributedOptions{Store: HTTPCounterStore{URL: srv.URL}, Clock: clock}),
+			}
+
+			got := 0
+			for i := 0; i < 10; i++ {
+				if replicas[i%2].AllowRequest() {
+					got++
+				}
+			}
+			if got != 4 {
+				t.Errorf("two replicas allowed %d requests, want 4 in total", got)
+			}
+		})
+	}
+}
+
+func TestDistributedFailurePolicy(t *testing.T) {
+	tests := []struct {
+		name   string
+		policy FailurePolicy
+		want   int
+	}{
+		{name: "fail open", policy: FailOpen, want: 5},
+		{name: "fail closed", policy: FailClosed, want: 0},
+		{name: "fail local", policy: FailLocal, want: 2},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var errs int
+			clock := newFakeClock()
+			d := NewDistributedWindow("client-1", 3, time.Minute, DistributedOptions{
+				Store:    downStore{},
+				Policy:   tt.policy,
+				Fallback: NewTokenBucket(1, 2, clock),
+				OnError:  func(error) { errs++ },
+				Clock:    clock,
+			})
+
+			got := 0
+			for i := 0; i < 5; i++ {
+				if d.AllowRequest() {
+					got++
+				}
+			}
+			if got != tt.want {
+				t.Errorf("allowed %d requests, want %d", got, tt.want)
+			}
+			if errs != 5 {
+				t.Errorf("OnError called %d times, want 5", errs)
+			}
+		})
+	}
+}
+
+func TestDistributedOptionsRejectFailLocalWithoutFallback(t *testing.T) {
+	defer func() {
+		if recover() == nil {
+			t.Error("FailLocal without a Fallback did not panic")
+		}
+	}()
+	NewDistributedWindow("client-1", 3, time.Minute, DistributedOptions{
+		Store:  downStore{},
+		Policy: FailLocal,
+	})
+}
+
+func TestExpiringMapStoreRejectsNonCounters(t *testing.T) {
+	em := NewExpiringMap(time.Minute)
+	defer em.Close()
+	em.Set("client-1:0", "not a number", time.Minute)
+	store := ExpiringMapStore{em}
+	ctx := context.Background()
+
+	if _, err := store.Incr(ctx, "client-1:0", 1, time.Minute); !errors.Is(err, ErrNotCounter) {
+		t.Errorf("Incr() on a string error = %v, want %v", err, ErrNotCounter)
+	}
+	if _, err := store.Get(ctx, "client-1:0"); !errors.Is(err, ErrNotCounter) {
+		t.Errorf("Get() on a string error = %v, want %v", err, ErrNotCounter)
+	}
+	if _, err := store.CompareAndSwap(ctx, "client-1:0", 0, 1, time.Minute); !errors.Is(err, ErrNotCounter) {
+		t.Errorf("CompareAndSwap() on a string error = %v, want %v", err, ErrNotCounter)
+	}
+
+	// A limiter hitting such a key treats it as a store failure.
+	var got error
+	d := NewDistributedGCRA("client-1:0", 1, 1, DistributedOptions{
+		Store:   store,
+		Policy:  FailClosed,
+		OnError: func(err error) { got = err },
+	})
+	if d.AllowRequest() || !errors.Is(got, ErrNotCounter) {
+		t.Errorf("AllowRequest() on a non-counter key reported %v, want a rejection with %v", got, ErrNotCounter)
+	}
+}
+
+func TestNewDistributedWindowRejectsBadWindow(t *testing.T) {
+	bad := []struct {
+		limit  int
+		window time.Duration
+	}{
+		{limit: 3, window: 0},
+		{limit: 3, window: -time.Minute},
+		{limit: -1, window: time.Minute},
+	}
+	for _, b := range bad {
+		func() {
+			defer func() {
+				if recover() == nil {
+					t.Errorf("NewDistributedWindow with limit %d and window %v did not panic", b.limit, b.window)
+				}
+			}()
+			NewDistributedWindow("client-1", b.limit, b.window, DistributedOptions{Store: downStore{}})
+		}()
+	}
+}
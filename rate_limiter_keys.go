@@ -0,0 +1,213 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"net/netip"
+	"strings"
+	"sync"
+	"time"
+)
+
+// KeyFunc extracts the identity a request is rate limited under.
+type KeyFunc func(r *http.Request) string
+
+// RemoteIPKey keys requests by client IP. X-Forwarded-For is only honoured
+// when the direct peer is inside one of trustedProxies; the header is then
+// walked right to left and the first address that is not itself a trusted
+// proxy is used, so clients cannot spoof their way out of a limit.
+func RemoteIPKey(trustedProxies ...netip.Prefix) KeyFunc {
+	trusted := func(addr netip.Addr) bool {
+		for _, p := range trustedProxies {
+			if p.Contains(addr) {
+				return true
+			}
+		}
+		return false
+	}
+
+	return func(r *http.Request) string {
+		host, _, err := net.SplitHostPort(r.RemoteAddr)
+		if err != nil {
+			host = r.RemoteAddr
+		}
+		peer, err := netip.ParseAddr(host)
+		if err != nil {
+			return host
+		}
+		peer = peer.Unmap()
+		if !trusted(peer) {
+			return peer.String()
+		}
+
+		hops := strings.Split(strings.Join(r.Header.Values("X-Forwarded-For"), ","), ",")
+		client := peer
+		for i := len(hops) - 1; i >= 0; i-- {
+			addr, err := netip.ParseAddr(strings.TrimSpace(hops[i]))
+			if err != nil {
+				break
+			}
+			client = addr.Unmap()
+			if !trusted(client) {
+				break
+			}
+		}
+		return client.String()
+	}
+}
+
+// HeaderKey keys requests by the value of a header such as X-API-Key.
+// Requests without the header share the empty key.
+func HeaderKey(name string) KeyFunc {
+	return func(r *http.Request) string {
+		return r.Header.Get(name)
+	}
+}
+
+// PathKey keys requests by URL path, giving every route its own budget.
+func PathKey() KeyFunc {
+	return func(r *http.Request) string {
+		return r.URL.Path
+	}
+}
+
+// Plan is the token bucket configuration applied to a key.
+type Plan struct {
+	Name  string
+	Rate  float64 // must be positive
+	Burst int
+}
+
+// PlanFunc chooses the plan for a key the first time it is seen.
+type PlanFunc func(key string) Plan
+
+// StaticPlans returns defaultPlan for every key except those listed in
+// overrides, e.g. API keys on a paid tier.
+func StaticPlans(defaultPlan Plan, overrides map[string]Plan) PlanFunc {
+	return func(key string) Plan {
+		if p, ok := overrides[key]; ok {
+			return p
+		}
+		return defaultPlan
+	}
+}
+
+// PrefixPlans returns the plan of the most specific prefix in table
+// containing the key, which must be an IP address as produced by
+// RemoteIPKey, and defaultPlan for keys in no listed range.
+func PrefixPlans(defaultPlan Plan, table *PrefixTable[Plan]) PlanFunc {
+	return func(key string) Plan {
+		addr, err := netip.ParseAddr(key)
+		if err != nil {
+			return defaultPlan
+		}
+		if _, p, ok := table.Lookup(addr); ok {
+			return p
+		}
+		return defaultPlan
+	}
+}
+
+// ClientClass is how RateLimitMiddleware treats a client before any limiter
+// is consulted.
+type ClientClass int
+
+const (
+	// ClassLimited clients are rate limited as usual.
+	ClassLimited ClientClass = iota
+	// ClassBypass clients, such as internal services, skip rate limiting.
+	ClassBypass
+	// ClassDeny clients are rejected with 403 Forbidden.
+	ClassDeny
+)
+
+// IPClassifier classifies requests by the most specific range in table
+// containing the client IP, resolved like RemoteIPKey. Clients in no listed
+// range are ClassLimited.
+func IPClassifier(table *PrefixTable[ClientClass], trustedProxies ...netip.Prefix) func(*http.Request) ClientClass {
+	clientIP := RemoteIPKey(trustedProxies...)
+	return func(r *http.Request) ClientClass {
+		addr, err := netip.ParseAddr(clientIP(r))
+		if err != nil {
+			return ClassLimited
+		}
+		_, class, _ := table.Lookup(addr)
+		return class
+	}
+}
+
+// KeyedLimiter keeps a separate RateLimiter per key. Limiters that have not
+// been used for idleTTL are evicted by the backing ExpiringMap, so memory is
+// bounded by the number of recently active keys rather than all keys ever
+// seen.
+type KeyedLimiter struct {
+	keyFunc  KeyFunc
+	plans    PlanFunc
+	idleTTL  time.Duration
+	limiters *ExpiringMap
+	mutex    sync.Mutex
+}
+
+// NewKeyedLimiter panics if idleTTL is not positive: limiters would expire
+// as soon as they were stored, so every request would get a fresh bucket.
+// Close stops the background eviction.
+func NewKeyedLimiter(keyFunc KeyFunc, plans PlanFunc, idleTTL time.Duration) *KeyedLimiter {
+	if idleTTL <= 0 {
+		panic("NewKeyedLimiter: non-positive idleTTL")
+	}
+	return &KeyedLimiter{
+		keyFunc:  keyFunc,
+		plans:    plans,
+		idleTTL:  idleTTL,
+		limiters: NewExpiringMap(max(idleTTL/2, time.Millisecond)),
+	}
+}
+
+// Close stops evicting idle limiters.
+func (kl *KeyedLimiter) Close() {
+	kl.limiters.Close()
+}
+
+// LimiterFor returns the limiter for key, creating it from the key's plan if
+// needed, and pushes back its idle deadline.
+func (kl *KeyedLimiter) LimiterFor(key string) *RateLimiter {
+	kl.mutex.Lock()
+	defer kl.mutex.Unlock()
+
+	if v, ok := kl.limiters.Get(key); ok {
+		rl := v.(*RateLimiter)
+		kl.limiters.Set(key, rl, kl.idleTTL)
+		return rl
+	}
+	plan := kl.plans(key)
+	rl := NewTokenBucket(plan.Rate, plan.Burst, nil)
+	kl.limiters.Set(key, rl, kl.idleTTL)
+	return rl
+}
+
+// Key returns the key r is limited under.
+func (kl *KeyedLimiter) Key(r *http.Request) string {
+	return kl.keyFunc(r)
+}
+
+// ForRequest returns the limiter for the key r is limited under.
+func (kl *KeyedLimiter) ForRequest(r *http.Request) Limiter {
+	return kl.LimiterFor(kl.keyFunc(r))
+}
+
+func (kl *KeyedLimiter) Allow(r *http.Request) bool {
+	return kl.LimiterFor(kl.keyFunc(r)).AllowRequest()
+}
+
+func keyedRateLimitedHandler(kl *KeyedLimiter) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if kl.Allow(r) {
+			w.WriteHeader(http.StatusOK)
+			fmt.Fprintln(w, "Request allowed")
+		} else {
+			w.WriteHeader(http.StatusTooManyRequests)
+			fmt.Fprintln(w, "Too many requests. Slow down!")
+		}
+	}
+}
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"net/http/httptest"
+	"net/netip"
+	"testing"
+	"time"
+)
+
+func TestRemoteIPKey(t *testing.T) {
+	proxies := []netip.Prefix{netip.MustParsePrefix("10.0.0.0/8")}
+
+	tests := []struct {
+		name       string
+		remoteAddr string
+		xff        string
+		want       string
+	}{
+		{name: "direct client", remoteAddr: "203.0.113.7:5000", want: "203.0.113.7"},
+		{name: "untrusted peer ignores header", remoteAddr: "203.0.113.7:5000", xff: "198.51.100.1", want: "203.0.113.7"},
+		{name: "trusted proxy", remoteAddr: "10.0.0.1:5000", xff: "198.51.100.1", want: "198.51.100.1"},
+		{name: "spoofed leftmost hop", remoteAddr: "10.0.0.1:5000", xff: "1.2.3.4, 198.51.100.1, 10.0.0.2", want: "198.51.100.1"},
+		{name: "trusted proxy without header", remoteAddr: "10.0.0.1:5000", want: "10.0.0.1"},
+		{name: "ipv6 client", remoteAddr: "[2001:db8::1]:5000", want: "2001:db8::1"},
+	}
+
+	key := RemoteIPKey(proxies...)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest("GET", "/api", nil)
+			r.RemoteAddr = tt.remoteAddr
+			if tt.xff != "" {
+				r.Header.Set("X-Forwarded-For", tt.xff)
+			}
+			if got := key(r); got != tt.want {
+				t.Errorf("RemoteIPKey() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestKeyedLimiterIsolatesKeys(t *testing.T) {
+	plans := StaticPlans(Plan{Name: "free", Rate: 1, Burst: 2}, map[string]Plan{
+		"pro-key": {Name: "pro", Rate: 1, Burst: 5},
+	})
+	kl := NewKeyedLimiter(HeaderKey("X-API-Key"), plans, time.Minute)
+	defer kl.Close()
+
+	allowed := func(apiKey string, n int) int {
+		got := 0
+		for i := 0; i < n; i++ {
+			r := httptest.NewRequest("GET", "/api", nil)
+			r.Header.Set("X-API-Key", apiKey)
+			if kl.Allow(r) {
+				got++
+			}
+		}
+		return got
+	}
+
+	if got := allowed("noisy", 10); got != 2 {
+		t.Errorf("noisy client allowed %d, want 2", got)
+	}
+	if got := allowed("quiet", 1); got != 1 {
+		t.Errorf("quiet client allowed %d after noisy client, want 1", got)
+	}
+	if got := allowed("pro-key", 10); got != 5 {
+		t.Errorf("pro client allowed %d, want 5", got)
+	}
+}
+
+func TestKeyedLimiterEvictsIdleKeys(t *testing.T) {
+	kl := NewKeyedLimiter(HeaderKey("X-API-Key"), StaticPlans(Plan{Rate: 0.001, Burst: 1}, nil), 20*time.Millisecond)
+	defer kl.Close()
+
+	if !kl.LimiterFor("idle").AllowRequest() {
+		t.Fatal("first request denied")
+	}
+	if kl.LimiterFor("idle").AllowRequest() {
+		t.Fatal("second request allowed with a burst of 1")
+	}
+
+	// Once idle for longer than idleTTL the key's bucket is dropped by the
+	// cleanup goroutine and the client starts over with a full bucket.
+	time.Sleep(100 * time.Millisecond)
+	kl.limiters.mutex.Lock()
+	_, stored := kl.limiters.store["idle"]
+	kl.limiters.mutex.Unlock()
+	if stored {
+		t.Error("idle key still stored after idleTTL")
+	}
+	if !kl.LimiterFor("idle").AllowRequest() {
+		t.Error("request after eviction denied, want a fresh bucket")
+	}
+}
+
+func TestNewKeyedLimiterRejectsZeroTTL(t *testing.T) {
+	defer func() {
+		if recover() == nil {
+			t.Error("NewKeyedLimiter with idleTTL 0 did not panic")
+		}
+	}()
+	NewKeyedLimiter(PathKey(), StaticPlans(Plan{Rate: 1, Burst: 1}, nil), 0)
+}
+
+func TestPrefixPlans(t *testing.T) {
+	free := Plan{Name: "free", Rate: 1, Burst: 5}
+	partners := NewPrefixTable[Plan]()
+	partners.Insert(netip.MustParsePrefix("198.51.100.0/24"), Plan{Name: "partner", Rate: 50, Burst: 100})
+	plans := PrefixPlans(free, partners)
+
+	tests := []struct {
+		key  string
+		want string
+	}{
+		{key: "198.51.100.20", want: "partner"},
+		{key: "203.0.113.1", want: "free"},
+		{key: "not-an-ip", want: "free"},
+	}
+	for _, tt := range tests {
+		if got := plans(tt.key).Name; got != tt.want {
+			t.Errorf("plan for %q = %q, want %q", tt.key, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,178 @@
+package main
+
+import (
+	"encoding/json"
+	"math"
+	"net/http"
+	"path"
+	"strconv"
+	"time"
+)
+
+// RateLimitOptions configures RateLimitMiddleware.
+type RateLimitOptions struct {
+	// Limiter returns the limiter a request is checked against. Use
+	// SingleLimiter for one global limiter or KeyedLimiter.ForRequest for
+	// per-client limits.
+	Limiter func(r *http.Request) Limiter
+
+	// MaxWait, when positive, holds a rejected request for up to this long
+	// waiting for capacity instead of answering 429 immediately.
+	MaxWait time.Duration
+
+	// ErrorBody builds the value encoded as JSON in 429 responses. When nil
+	// a default body with an error code and retry_after is used.
+	ErrorBody func(r *http.Request, res RateLimitResult) any
+
+	// ExemptPaths are path.Match patterns that bypass rate limiting.
+	ExemptPaths []string
+
+	// ExemptKeys bypass rate limiting when Key(r) returns one of them.
+	ExemptKeys []string
+	Key        KeyFunc
+
+	// Classify, when set, runs first and can let a client bypass limiting
+	// or reject it outright, e.g. IPClassifier with internal ranges mapped
+	// to ClassBypass and a deny list mapped to ClassDeny.
+	Classify func(r *http.Request) ClientClass
+}
+
+// SingleLimiter applies the same limiter to every request.
+func SingleLimiter(l Limiter) func(*http.Request) Limiter {
+	return func(*http.Request) Limiter {
+		return l
+	}
+}
+
+// pollInterval is how often a queued request re-checks a limiter that cannot
+// say when capacity will be available.
+const pollInterval = 10 * time.Millisecond
+
+// RateLimitMiddleware rejects requests over the limit with 429 Too Many
+// Requests and a JSON body. Every limited response carries the
+// RateLimit-Limit, RateLimit-Remaining and RateLimit-Reset headers from the
+// IETF RateLimit header fields draft when the limiter can report them, and
+// rejections carry Retry-After.
+func RateLimitMiddleware(opts RateLimitOptions) func(http.Handler) http.Handler {
+	exemptKeys := make(map[string]bool, len(opts.ExemptKeys))
+	for _, k := range opts.ExemptKeys {
+		exemptKeys[k] = true
+	}
+	errorBody := opts.ErrorBody
+	if errorBody == nil {
+		errorBody = defaultRateLimitBody
+	}
+
+	exempt := func(r *http.Request) bool {
+		for _, pattern := range opts.ExemptPaths {
+			if ok, _ := path.Match(pattern, r.URL.Path); ok {
+				return true
+			}
+		}
+		return opts.Key != nil && exemptKeys[opts.Key(r)]
+	}
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			class := ClassLimited
+			if opts.Classify != nil {
+				class = opts.Classify(r)
+			}
+			if class == ClassDeny {
+				w.Header().Set("Content-Type", "application/json")
+				w.WriteHeader(http.StatusForbidden)
+				json.NewEncoder(w).Encode(forbiddenBody)
+				return
+			}
+			if class == ClassBypass || exempt(r) {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			limiter := opts.Limiter(r)
+			res := checkLimit(limiter)
+			if !res.Allowed && opts.MaxWait > 0 {
+				res = waitForCapacity(r, limiter, res, opts.MaxWait)
+			}
+
+			setRateLimitHeaders(w.Header(), res)
+			if res.Allowed {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			if res.RetryAfter > 0 {
+				w.Header().Set("Retry-After", strconv.Itoa(ceilSeconds(res.RetryAfter)))
+			}
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusTooManyRequests)
+			json.NewEncoder(w).Encode(errorBody(r, res))
+		})
+	}
+}
+
+func checkLimit(l Limiter) RateLimitResult {
+	if rl, ok := l.(ReportingLimiter); ok {
+		return rl.Take()
+	}
+	return RateLimitResult{Allowed: l.AllowRequest()}
+}
+
+// waitForCapacity retries l until it admits the request, maxWait elapses or
+// the client goes away. It gives up early when the limiter reports that
+// capacity will not return before the deadline.
+func waitForCapacity(r *http.Request, l Limiter, res RateLimitResult, maxWait time.Duration) RateLimitResult {
+	deadline := time.Now().Add(maxWait)
+	for !res.Allowed {
+		wait := res.RetryAfter
+		if wait <= 0 {
+			wait = pollInterval
+		}
+		if time.Until(deadline) < wait {
+			return res
+		}
+
+		timer := time.NewTimer(wait)
+		select {
+		case <-r.Context().Done():
+			timer.Stop()
+			return res
+		case <-timer.C:
+		}
+		res = checkLimit(l)
+	}
+	return res
+}
+
+func setRateLimitHeaders(h http.Header, res RateLimitResult) {
+	if res.Limit <= 0 {
+		return
+	}
+	h.Set("RateLimit-Limit", strconv.Itoa(res.Limit))
+	h.Set("RateLimit-Remaining", strconv.Itoa(res.Remaining))
+	h.Set("RateLimit-Reset", strconv.Itoa(ceilSeconds(res.Reset)))
+}
+
+func ceilSeconds(d time.Duration) int {
+	return int(math.Ceil(d.Seconds()))
+}
+
+var forbiddenBody = struct {
+	Error   string `json:"error"`
+	Message string `json:"message"`
+}{
+	Error:   "forbidden",
+	Message: "Requests from this address are not allowed.",
+}
+
+func defaultRateLimitBody(_ *http.Request, res RateLimitResult) any {
+	return struct {
+		Error      string `json:"error"`
+		Message    string `json:"message"`
+		RetryAfter int    `json:"retry_after,omitempty"`
+	}{
+		Error:      "rate_limited",
+		Message:    "Too many requests. Slow down!",
+		RetryAfter: ceilSeconds(res.RetryAfter),
+	}
+}
@@ -0,0 +1,186 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"testing"
+	"time"
+)
+
+func okHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+}
+
+func TestRateLimitMiddlewareHeaders(t *testing.T) {
+	clock := newFakeClock()
+	rl := NewTokenBucket(1, 2, clock)
+	h := RateLimitMiddleware(RateLimitOptions{Limiter: SingleLimiter(rl)})(okHandler())
+
+	tests := []struct {
+		name          string
+		wantStatus    int
+		wantRemaining string
+		wantRetry     string
+	}{
+		{name: "first request", wantStatus: http.StatusOK, wantRemaining: "1"},
+		{name: "second request", wantStatus: http.StatusOK, wantRemaining: "0"},
+		{name: "over limit", wantStatus: http.StatusTooManyRequests, wantRemaining: "0", wantRetry: "1"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			h.ServeHTTP(w, httptest.NewRequest("GET", "/api", nil))
+
+			if w.Code != tt.wantStatus {
+				t.Errorf("status = %d, want %d", w.Code, tt.wantStatus)
+			}
+			if got := w.Header().Get("RateLimit-Limit"); got != "2" {
+				t.Errorf("RateLimit-Limit = %q, want %q", got, "2")
+			}
+			if got := w.Header().Get("RateLimit-Remaining"); got != tt.wantRemaining {
+				t.Errorf("RateLimit-Remaining = %q, want %q", got, tt.wantRemaining)
+			}
+			if got := w.Header().Get("Retry-After"); got != tt.wantRetry {
+				t.Errorf("Retry-After = %q, want %q", got, tt.wantRetry)
+			}
+		})
+	}
+}
+
+func TestRateLimitMiddlewareHeadersSlidingWindow(t *testing.T) {
+	clock := newFakeClock()
+	l := NewSlidingWindowLog(1, time.Minute, clock)
+	h := RateLimitMiddleware(RateLimitOptions{Limiter: SingleLimiter(l)})(okHandler())
+
+	h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/api", nil))
+	clock.Advance(15 * time.Second)
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest("GET", "/api", nil))
+
+	if w.Code != http.StatusTooManyRequests {
+		t.Fatalf("status = %d, want %d", w.Code, http.StatusTooManyRequests)
+	}
+	for header, want := range map[string]string{
+		"Retry-After":         "45",
+		"RateLimit-Limit":     "1",
+		"RateLimit-Remaining": "0",
+		"RateLimit-Reset":     "45",
+	} {
+		if got := w.Header().Get(header); got != want {
+			t.Errorf("%s = %q, want %q", header, got, want)
+		}
+	}
+}
+
+func TestRateLimitMiddlewareErrorBody(t *testing.T) {
+	rl := NewTokenBucket(1, 1, newFakeClock())
+	h := RateLimitMiddleware(RateLimitOptions{
+		Limiter: SingleLimiter(rl),
+		ErrorBody: func(r *http.Request, res RateLimitResult) any {
+			return map[string]any{"code": 4291, "path": r.URL.Path}
+		},
+	})(okHandler())
+
+	h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/api", nil))
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest("GET", "/api", nil))
+
+	var body map[string]any
+	if err := json.NewDecoder(w.Body).Decode(&body); err != nil {
+		t.Fatalf("decoding body: %v", err)
+	}
+	if body["code"] != float64(4291) || body["path"] != "/api" {
+		t.Errorf("body = %v, want custom error body", body)
+	}
+}
+
+func TestRateLimitMiddlewareExemptions(t *testing.T) {
+	rl := NewTokenBucket(1, 1, newFakeClock())
+	rl.AllowRequest()
+	h := RateLimitMiddleware(RateLimitOptions{
+		Limiter:     SingleLimiter(rl),
+		ExemptPaths: []string{"/healthz", "/internal/*"},
+		ExemptKeys:  []string{"ops-key"},
+		Key:         HeaderKey("X-API-Key"),
+	})(okHandler())
+
+	tests := []struct {
+		name   string
+		path   string
+		apiKey string
+		want   int
+	}{
+		{name: "exempt path", path: "/healthz", want: http.StatusOK},
+		{name: "exempt pattern", path: "/internal/metrics", want: http.StatusOK},
+		{name: "exempt key", path: "/api", apiKey: "ops-key", want: http.StatusOK},
+		{name: "limited", path: "/api", apiKey: "other", want: http.StatusTooManyRequests},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest("GET", tt.path, nil)
+			r.Header.Set("X-API-Key", tt.apiKey)
+			w := httptest.NewRecorder()
+			h.ServeHTTP(w, r)
+			if w.Code != tt.want {
+				t.Errorf("status = %d, want %d", w.Code, tt.want)
+			}
+		})
+	}
+}
+
+func TestRateLimitMiddlewareClassification(t *testing.T) {
+	classes := NewPrefixTable[ClientClass]()
+	classes.Insert(netip.MustParsePrefix("10.0.0.0/8"), ClassBypass)
+	classes.Insert(netip.MustParsePrefix("10.66.0.0/16"), ClassDeny)
+	classes.Insert(netip.MustParsePrefix("203.0.113.0/24"), ClassDeny)
+
+	rl := NewTokenBucket(1, 1, newFakeClock())
+	h := RateLimitMiddleware(RateLimitOptions{
+		Limiter:  SingleLimiter(rl),
+		Classify: IPClassifier(classes),
+	})(okHandler())
+
+	tests := []struct {
+		name       string
+		remoteAddr string
+		want       int
+	}{
+		{name: "external client within limit", remoteAddr: "198.51.100.1:1234", want: http.StatusOK},
+		{name: "internal range bypasses", remoteAddr: "10.0.0.5:1234", want: http.StatusOK},
+		{name: "internal range bypasses again", remoteAddr: "10.0.0.5:1234", want: http.StatusOK},
+		{name: "external client over limit", remoteAddr: "198.51.100.1:1234", want: http.StatusTooManyRequests},
+		{name: "denied range inside internal range", remoteAddr: "10.66.1.1:1234", want: http.StatusForbidden},
+		{name: "deny list", remoteAddr: "203.0.113.9:1234", want: http.StatusForbidden},
+	}
+
+	for _, tt := range tests {
+		r := httptest.NewRequest("GET", "/api", nil)
+		r.RemoteAddr = tt.remoteAddr
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		if w.Code != tt.want {
+			t.Errorf("%s: status = %d, want %d", tt.name, w.Code, tt.want)
+		}
+	}
+}
+
+func TestRateLimitMiddlewareQueuesUntilCapacity(t *testing.T) {
+	rl := NewTokenBucket(20, 1, nil)
+	h := RateLimitMiddleware(RateLimitOptions{
+		Limiter: SingleLimiter(rl),
+		MaxWait: time.Second,
+	})(okHandler())
+
+	h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/api", nil))
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest("GET", "/api", nil))
+	if w.Code != http.StatusOK {
+		t.Errorf("queued request status = %d, want %d", w.Code, http.StatusOK)
+	}
+}
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// counterStoreRequest is the body of every call to a CounterStoreHandler.
+// Op is one of "incr", "get" or "cas".
+type counterStoreRequest struct {
+	Op    string `json:"op"`
+	Key   string `json:"key"`
+	Delta int64  `json:"delta,omitempty"`
+	Old   int64  `json:"old,omitempty"`
+	New   int64  `json:"new,omitempty"`
+	TTL   int64  `json:"ttl_ns,omitempty"`
+}
+
+type counterStoreResponse struct {
+	Value   int64  `json:"value"`
+	Swapped bool   `json:"swapped"`
+	Error   string `json:"error,omitempty"`
+}
+
+// CounterStoreHandler serves store over HTTP so that limiters in other
+// processes can share it through HTTPCounterStore. Each call runs as one
+// operation on store, so Incr and CompareAndSwap are exactly as atomic as
+// they are in store itself.
+func CounterStoreHandler(store CounterStore) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			w.Header().Set("Allow", http.MethodPost)
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		var req counterStoreRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "Malformed request", http.StatusBadRequest)
+			return
+		}
+
+		var (
+			res counterStoreResponse
+			err error
+		)
+		ttl := time.Duration(req.TTL)
+		switch req.Op {
+		case "incr":
+			res.Value, err = store.Incr(r.Context(), req.Key, req.Delta, ttl)
+		case "get":
+			res.Value, err = store.Get(r.Context(), req.Key)
+		case "cas":
+			res.Swapped, err = store.CompareAndSwap(r.Context(), req.Key, req.Old, req.New, ttl)
+		default:
+			http.Error(w, "Unknown operation", http.StatusBadRequest)
+			return
+		}
+
+		status := http.StatusOK
+		if err != nil {
+			res = counterStoreResponse{Error: err.Error()}
+			status = http.StatusBadGateway
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(status)
+		json.NewEncoder(w).Encode(res)
+	})
+}
+
+// HTTPCounterStore is a CounterStore backed by a CounterStoreHandler at URL,
+// letting every replica of a service share one set of counters. A nil Client
+// uses http.DefaultClient. Set DistributedOptions.Timeout to bound each call.
+type HTTPCounterStore struct {
+	URL    string
+	Client *http.Client
+}
+
+func (s HTTPCounterStore) Incr(ctx context.Context, key string, delta int64, ttl time.Duration) (int64, error) {
+	res, err := s.do(ctx, counterStoreRequest{Op: "incr", Key: key, Delta: delta, TTL: int64(ttl)})
+	return res.Value, err
+}
+
+func (s HTTPCounterStore) Get(ctx context.Context, key string) (int64, error) {
+	res, err := s.do(ctx, counterStoreRequest{Op: "get", Key: key})
+	return res.Value, err
+}
+
+func (s HTTPCounterStore) CompareAndSwap(ctx context.Context, key string, old, new int64, ttl time.Duration) (bool, error) {
+	res, err := s.do(ctx, counterStoreRequest{Op: "cas", Key: key, Old: old, New: new, TTL: int64(ttl)})
+	return res.Swapped, err
+}
+
+func (s HTTPCounterStore) do(ctx context.Context, req counterStoreRequest) (counterStoreResponse, error) {
+	var res counterStoreResponse
+	body, err := json.Marshal(req)
+	if err != nil {
+		return res, err
+	}
+	httpReq, err := http.NewRequestWithContext(ctx, http.MethodPost, s.URL, bytes.NewReader(body))
+	if err != nil {
+		return res, err
+	}
+	httpReq.Header.Set("Content-Type", "application/json")
+
+	client := s.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(httpReq)
+	if err != nil {
+		return res, err
+	}
+	defer resp.Body.Close()
+	if err := json.NewDecoder(resp.Body).Decode(&res); err != nil {
+		return res, fmt.Errorf("counter store %s: %s", req.Op, resp.Status)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return counterStoreResponse{}, fmt.Errorf("counter store %s: %s", req.Op, res.Error)
+	}
+	return res, nil
+}
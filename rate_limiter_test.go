@@ -0,0 +1,84 @@
+package main
+
+import (
+	"sync"
+	"testing"
+	"time"
+)
+
+type fakeClock struct {
+	mu  sync.Mutex
+	now time.Time
+}
+
+func newFakeClock() *fakeClock {
+	return &fakeClock{now: time.Unix(1_700_000_000, 0)}
+}
+
+func (c *fakeClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+func (c *fakeClock) Advance(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.now = c.now.Add(d)
+}
+
+func TestTokenBucket(t *testing.T) {
+	tests := []struct {
+		name    string
+		rate    float64
+		burst   int
+		advance time.Duration
+		want    int
+	}{
+		{name: "burst only", rate: 2, burst: 5, advance: 0, want: 5},
+		{name: "refill after one second", rate: 2, burst: 5, advance: time.Second, want: 2},
+		{name: "refill capped at burst", rate: 2, burst: 5, advance: time.Hour, want: 5},
+		{name: "fractional rate", rate: 0.5, burst: 1, advance: 3 * time.Second, want: 1},
+		{name: "fractional rate not yet refilled", rate: 0.5, burst: 1, advance: 1500 * time.Millisecond, want: 0},
+		{name: "high rate", rate: 5000, burst: 10000, advance: 100 * time.Millisecond, want: 500},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			clock := newFakeClock()
+			rl := NewTokenBucket(tt.rate, tt.burst, clock)
+			if tt.advance > 0 {
+				for rl.AllowRequest() {
+				}
+				clock.Advance(tt.advance)
+			}
+
+			got := 0
+			for rl.AllowRequest() {
+				got++
+			}
+			if got != tt.want {
+				t.Errorf("allowed %d requests, want %d", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestTokenBucketAllowN(t *testing.T) {
+	clock := newFakeClock()
+	rl := NewTokenBucket(1, 3, clock)
+
+	if !rl.AllowN(3) {
+		t.Fatal("AllowN(3) on a full bucket = false, want true")
+	}
+	if rl.AllowN(1) {
+		t.Fatal("AllowN(1) on an empty bucket = true, want false")
+	}
+	clock.Advance(1500 * time.Millisecond)
+	if rl.AllowN(2) {
+		t.Error("AllowN(2) with 1.5 tokens = true, want false")
+	}
+	if !rl.AllowN(1) {
+		t.Error("AllowN(1) with 1.5 tokens = false, want true")
+	}
+}
@@ -1,84 +1,277 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"math/bits"
+	"runtime"
+	"sync"
 	"sync/atomic"
 )
 
-type RingBuffer struct {
-	buffer []int
-	size   int32
-	read   int32 
-	write  int32 
+var ErrRingBufferClosed = errors.New("ring buffer closed")
+
+// Put and Take spin this many times before parking; the first activeSpins
+// iterations retry immediately, the rest yield the processor.
+const (
+	spinLimit   = 64
+	activeSpins = 16
+)
+
+// RingBuffer is a bounded lock-free queue for exactly one producer goroutine
+// and one consumer goroutine. Enqueue and EnqueueBatch may only be called by
+// the producer; Dequeue, DequeueBatch and Peek only by the consumer. Size,
+// IsEmpty and IsFull are safe from anywhere but only a snapshot.
+//
+// read and write are free-running counters rather than wrapped indices: the
+// queue holds write-read items, all capacity slots are usable, and a slot is
+// found by masking with capacity-1, which is why capacity is a power of two.
+type RingBuffer[T any] struct {
+	buffer []T
+	mask   uint64
+	read   atomic.Uint64 // only stored by the consumer
+	write  atomic.Uint64 // only stored by the producer
+
+	// A side that has to park sets its waiting flag, re-checks the buffer
+	// and then blocks on its channel; the other side signals only when the
+	// flag is set, which keeps the fast path free of channel operations.
+	putWaiting  atomic.Bool
+	takeWaiting atomic.Bool
+	notFull     chan struct{}
+	notEmpty    chan struct{}
+	closed      atomic.Bool
+	done        chan struct{}
+	closeOnce   sync.Once
 }
 
-func NewRingBuffer(size int) *RingBuffer {
-	return &RingBuffer{
-		buffer: make([]int, size),
-		size:   int32(size),
+// NewRingBuffer creates a buffer holding at least capacity items. The
+// capacity is rounded up to the next power of two.
+func NewRingBuffer[T any](capacity int) *RingBuffer[T] {
+	size := roundUpPow2(capacity)
+	return &RingBuffer[T]{
+		buffer:   make([]T, size),
+		mask:     uint64(size - 1),
+		notFull:  make(chan struct{}, 1),
+		notEmpty: make(chan struct{}, 1),
+		done:     make(chan struct{}),
 	}
 }
 
-func (rb *RingBuffer) Enqueue(value int) bool {
-	writePos := atomic.LoadInt32(&rb.write)
-	readPos := atomic.LoadInt32(&rb.read)
-
-	if (writePos+1)%rb.size == readPos {
-		return false 
+func roundUpPow2(n int) int {
+	if n <= 1 {
+		return 1
 	}
+	return 1 << bits.Len(uint(n-1))
+}
 
-	rb.buffer[writePos] = value
+// Cap returns the number of items the buffer can hold.
+func (rb *RingBuffer[T]) Cap() int {
+	return len(rb.buffer)
+}
 
-	atomic.StoreInt32(&rb.write, (writePos+1)%rb.size)
+// Enqueue adds value if there is room. It returns false when the buffer is
+// full or closed.
+func (rb *RingBuffer[T]) Enqueue(value T) bool {
+	if rb.closed.Load() {
+		return false
+	}
+	writePos := rb.write.Load()
+	if writePos-rb.read.Load() == uint64(len(rb.buffer)) {
+		return false
+	}
+	rb.buffer[writePos&rb.mask] = value
+	rb.write.Store(writePos + 1)
+	rb.wake(&rb.takeWaiting, rb.notEmpty)
 	return true
 }
 
-func (rb *RingBuffer) Dequeue() (int, bool) {
-	readPos := atomic.LoadInt32(&rb.read)
-	writePos := atomic.LoadInt32(&rb.write)
+// EnqueueBatch adds as many of values as fit and returns how many were added.
+// The whole batch becomes visible to the consumer at once.
+func (rb *RingBuffer[T]) EnqueueBatch(values []T) int {
+	if rb.closed.Load() {
+		return 0
+	}
+	writePos := rb.write.Load()
+	free := uint64(len(rb.buffer)) - (writePos - rb.read.Load())
+	n := min(uint64(len(values)), free)
+	for i := uint64(0); i < n; i++ {
+		rb.buffer[(writePos+i)&rb.mask] = values[i]
+	}
+	rb.write.Store(writePos + n)
+	if n > 0 {
+		rb.wake(&rb.takeWaiting, rb.notEmpty)
+	}
+	return int(n)
+}
 
-	if readPos == writePos {
-		return 0, false 
+func (rb *RingBuffer[T]) Dequeue() (T, bool) {
+	var zero T
+	readPos := rb.read.Load()
+	if readPos == rb.write.Load() {
+		return zero, false
 	}
+	slot := &rb.buffer[readPos&rb.mask]
+	value := *slot
+	*slot = zero // drop the reference so the GC can collect it
+	rb.read.Store(readPos + 1)
+	rb.wake(&rb.putWaiting, rb.notFull)
+	return value, true
+}
 
-	value := rb.buffer[readPos]
+// DequeueBatch fills dst with up to len(dst) items and returns how many were
+// removed.
+func (rb *RingBuffer[T]) DequeueBatch(dst []T) int {
+	var zero T
+	readPos := rb.read.Load()
+	n := min(uint64(len(dst)), rb.write.Load()-readPos)
+	for i := uint64(0); i < n; i++ {
+		slot := &rb.buffer[(readPos+i)&rb.mask]
+		dst[i] = *slot
+		*slot = zero
+	}
+	rb.read.Store(readPos + n)
+	if n > 0 {
+		rb.wake(&rb.putWaiting, rb.notFull)
+	}
+	return int(n)
+}
 
-	atomic.StoreInt32(&rb.read, (readPos+1)%rb.size)
-	return value, true
+// Peek returns the next item without removing it.
+func (rb *RingBuffer[T]) Peek() (T, bool) {
+	readPos := rb.read.Load()
+	if readPos == rb.write.Load() {
+		var zero T
+		return zero, false
+	}
+	return rb.buffer[readPos&rb.mask], true
+}
+
+func (rb *RingBuffer[T]) Size() int {
+	// Load read first: read never passes write, so a later write is at least
+	// as large and the difference cannot underflow.
+	readPos := rb.read.Load()
+	return int(rb.write.Load() - readPos)
+}
+
+func (rb *RingBuffer[T]) IsEmpty() bool {
+	return rb.Size() == 0
 }
 
-func (rb *RingBuffer) Size() int {
-	writePos := atomic.LoadInt32(&rb.write)
-	readPos := atomic.LoadInt32(&rb.read)
+func (rb *RingBuffer[T]) IsFull() bool {
+	return rb.Size() == len(rb.buffer)
+}
 
-	if writePos >= readPos {
-		return int(writePos - readPos)
+func (rb *RingBuffer[T]) wake(waiting *atomic.Bool, ch chan struct{}) {
+	if waiting.Load() {
+		select {
+		case ch <- struct{}{}:
+		default:
+		}
 	}
-	return int(rb.size - readPos + writePos)
 }
 
-func (rb *RingBuffer) IsEmpty() bool {
-	return atomic.LoadInt32(&rb.read) == atomic.LoadInt32(&rb.write)
+// park blocks until the other side signals ch, the buffer is closed or ctx
+// is done. ready is re-checked after announcing the wait so that a signal
+// sent in between is not missed.
+func (rb *RingBuffer[T]) park(ctx context.Context, waiting *atomic.Bool, ch chan struct{}, ready func() bool) error {
+	waiting.Store(true)
+	defer waiting.Store(false)
+	if ready() || rb.closed.Load() {
+		return nil
+	}
+	select {
+	case <-ch:
+		return nil
+	case <-rb.done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
 }
 
-func (rb *RingBuffer) IsFull() bool {
-	writePos := atomic.LoadInt32(&rb.write)
-	readPos := atomic.LoadInt32(&rb.read)
-	return (writePos+1)%rb.size == readPos
+// Put adds value, waiting for room if the buffer is full. It returns
+// ErrRingBufferClosed once the buffer is closed, or ctx.Err() if ctx is done
+// first.
+func (rb *RingBuffer[T]) Put(ctx context.Context, value T) error {
+	notFull := func() bool { return !rb.IsFull() }
+	for spin := 0; ; spin++ {
+		if rb.Enqueue(value) {
+			return nil
+		}
+		if rb.closed.Load() {
+			return ErrRingBufferClosed
+		}
+		if spin < spinLimit {
+			if spin >= activeSpins {
+				runtime.Gosched()
+			}
+			continue
+		}
+		if err := rb.park(ctx, &rb.putWaiting, rb.notFull, notFull); err != nil {
+			return err
+		}
+	}
+}
+
+// Take removes the next item, waiting for one if the buffer is empty. Items
+// enqueued before Close are still returned; after that Take returns
+// ErrRingBufferClosed.
+func (rb *RingBuffer[T]) Take(ctx context.Context) (T, error) {
+	notEmpty := func() bool { return !rb.IsEmpty() }
+	for spin := 0; ; spin++ {
+		if v, ok := rb.Dequeue(); ok {
+			return v, nil
+		}
+		if rb.closed.Load() {
+			// Close may have raced with a final Enqueue.
+			if v, ok := rb.Dequeue(); ok {
+				return v, nil
+			}
+			var zero T
+			return zero, ErrRingBufferClosed
+		}
+		if spin < spinLimit {
+			if spin >= activeSpins {
+				runtime.Gosched()
+			}
+			continue
+		}
+		if err := rb.park(ctx, &rb.takeWaiting, rb.notEmpty, notEmpty); err != nil {
+			var zero T
+			return zero, err
+		}
+	}
+}
+
+// Drain removes and returns every item currently in the buffer.
+func (rb *RingBuffer[T]) Drain() []T {
+	items := make([]T, rb.Size())
+	n := rb.DequeueBatch(items)
+	return items[:n]
+}
+
+// Close stops further Enqueue and Put calls and wakes every waiter. Items
+// already in the buffer can still be taken.
+func (rb *RingBuffer[T]) Close() {
+	rb.closeOnce.Do(func() {
+		rb.closed.Store(true)
+		close(rb.done)
+	})
 }
 
 func main() {
-	rb := NewRingBuffer(5) 
+	rb := NewRingBuffer[int](4)
 
-	fmt.Println(rb.Enqueue(1)) 
-	fmt.Println(rb.Enqueue(2)) 
-	fmt.Println(rb.Enqueue(3)) 
-	fmt.Println(rb.Enqueue(4)) 
-	fmt.Println(rb.Enqueue(5)) 
+	fmt.Println(rb.Enqueue(1))
+	fmt.Println(rb.Enqueue(2))
+	fmt.Println(rb.Enqueue(3))
+	fmt.Println(rb.Enqueue(4))
+	fmt.Println(rb.Enqueue(5))
 
-	fmt.Println(rb.Dequeue()) 
-	fmt.Println(rb.Dequeue()) 
-	fmt.Println(rb.Dequeue()) 
+	fmt.Println(rb.Dequeue())
+	fmt.Println(rb.Dequeue())
+	fmt.Println(rb.Dequeue())
 
 	fmt.Println(rb.Enqueue(6))
 	fmt.Println(rb.Enqueue(7))
@@ -0,0 +1,300 @@
+//go:build linux || darwin
+
+package main
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"os"
+	"sync"
+)
+
+var (
+	ErrRingBufferFull = errors.New("ring buffer full")
+	ErrRecordTooLarge = errors.New("record too large for slot")
+	ErrCorruptHeader  = errors.New("ring buffer file header is corrupt")
+	ErrCorruptRecord  = errors.New("ring buffer record is corrupt")
+)
+
+const (
+	mmapMagic      = 0x52425546 // "RBUF"
+	mmapVersion    = 1
+	mmapHeaderSize = 4096
+	mmapSlotHeader = 16 // length, crc32, sequence
+)
+
+// Header layout. head and tail are 8-byte aligned so each is updated by a
+// single aligned store and cannot be torn.
+const (
+	hdrMagic    = 0
+	hdrVersion  = 4
+	hdrSlotSize = 8
+	hdrCapacity = 12
+	hdrCRC      = 16 // over magic, version, slot size and capacity
+	hdrHead     = 24
+	hdrTail     = 32
+)
+
+// MmapRingBuffer is a persistent FIFO of byte records backed by a
+// memory-mapped file, so queued records survive a process crash. The file
+// is a header page holding the configuration and the head and tail
+// positions, followed by capacity fixed-size slots. Each slot stores a
+// length-prefixed record together with its position and a CRC32, which lets
+// Open detect records that were only partly written.
+//
+// Unlike RingBuffer it is guarded by a mutex and safe for concurrent use.
+type MmapRingBuffer struct {
+	file       *os.File
+	data       []byte
+	slotSize   int
+	capacity   uint64
+	head       uint64
+	tail       uint64
+	syncWrites bool
+	mutex      sync.Mutex
+}
+
+// MmapRingBufferOptions configures OpenMmapRingBuffer. Capacity and
+// MaxRecordSize only apply when the file is created; an existing file keeps
+// its own layout.
+type MmapRingBufferOptions struct {
+	Capacity      int // rounded up to a power of two, default 1024
+	MaxRecordSize int // default 256
+	// SyncWrites flushes every change to disk before returning, trading
+	// throughput for durability across power loss, not just process crashes.
+	SyncWrites bool
+}
+
+// OpenMmapRingBuffer opens or creates the queue file at path. On open the
+// records after the stored head are re-validated and the tail is set just
+// past the last intact one, which drops a torn final write and recovers
+// writes whose tail update did not reach the disk.
+func OpenMmapRingBuffer(path string, opts MmapRingBufferOptions) (*MmapRingBuffer, error) {
+	if opts.Capacity <= 0 {
+		opts.Capacity = 1024
+	}
+	if opts.MaxRecordSize <= 0 {
+		opts.MaxRecordSize = 256
+	}
+
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0o644)
+	if err != nil {
+		return nil, err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+
+	created := info.Size() == 0
+	slotSize := (mmapSlotHeader + opts.MaxRecordSize + 7) &^ 7
+	capacity := roundUpPow2(opts.Capacity)
+	if !created {
+		var hdr [hdrHead]byte
+		if _, err := f.ReadAt(hdr[:], 0); err != nil {
+			f.Close()
+			return nil, fmt.Errorf("%w: %v", ErrCorruptHeader, err)
+		}
+		if !validMmapHeader(hdr[:]) {
+			f.Close()
+			return nil, ErrCorruptHeader
+		}
+		slotSize = int(binary.LittleEndian.Uint32(hdr[hdrSlotSize:]))
+		capacity = int(binary.LittleEndian.Uint32(hdr[hdrCapacity:]))
+		// The checksum only proves the header is intact, not that the file
+		// behind it is as long as the layout it describes.
+		if slotSize < mmapSlotHeader || slotSize%8 != 0 ||
+			capacity <= 0 || capacity&(capacity-1) != 0 ||
+			info.Size() < int64(mmapHeaderSize)+int64(capacity)*int64(slotSize) {
+			f.Close()
+			return nil, fmt.Errorf("%w: layout does not match file size %d", ErrCorruptHeader, info.Size())
+		}
+	}
+
+	size := mmapHeaderSize + capacity*slotSize
+	if created {
+		if err := f.Truncate(int64(size)); err != nil {
+			f.Close()
+			return nil, err
+		}
+	}
+	data, err := mmapFile(f, size, true)
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+
+	rb := &MmapRingBuffer{
+		file:       f,
+		data:       data,
+		slotSize:   slotSize,
+		capacity:   uint64(capacity),
+		syncWrites: opts.SyncWrites,
+	}
+	if created {
+		binary.LittleEndian.PutUint32(data[hdrMagic:], mmapMagic)
+		binary.LittleEndian.PutUint32(data[hdrVersion:], mmapVersion)
+		binary.LittleEndian.PutUint32(data[hdrSlotSize:], uint32(slotSize))
+		binary.LittleEndian.PutUint32(data[hdrCapacity:], uint32(capacity))
+		binary.LittleEndian.PutUint32(data[hdrCRC:], crc32.ChecksumIEEE(data[:hdrCRC]))
+		if err := rb.Sync(); err != nil {
+			rb.Close()
+			return nil, err
+		}
+	} else {
+		rb.recover()
+	}
+	return rb, nil
+}
+
+func validMmapHeader(hdr []byte) bool {
+	return binary.LittleEndian.Uint32(hdr[hdrMagic:]) == mmapMagic &&
+		binary.LittleEndian.Uint32(hdr[hdrVersion:]) == mmapVersion &&
+		binary.LittleEndian.Uint32(hdr[hdrCRC:]) == crc32.ChecksumIEEE(hdr[:hdrCRC])
+}
+
+func (rb *MmapRingBuffer) recover() {
+	rb.head = binary.LittleEndian.Uint64(rb.data[hdrHead:])
+	tail := rb.head
+	for tail-rb.head < rb.capacity {
+		if _, ok := rb.readSlot(tail); !ok {
+			break
+		}
+		tail++
+	}
+	rb.tail = tail
+	binary.LittleEndian.PutUint64(rb.data[hdrTail:], tail)
+}
+
+func (rb *MmapRingBuffer) slotOffset(pos uint64) int {
+	return mmapHeaderSize + int(pos&(rb.capacity-1))*rb.slotSize
+}
+
+func (rb *MmapRingBuffer) slot(pos uint64) []byte {
+	off := rb.slotOffset(pos)
+	return rb.data[off : off+rb.slotSize]
+}
+
+// readSlot returns the record at pos if the slot holds an intact record
+// written for that position.
+func (rb *MmapRingBuffer) readSlot(pos uint64) ([]byte, bool) {
+	s := rb.slot(pos)
+	n := int(binary.LittleEndian.Uint32(s[0:]))
+	if n > rb.slotSize-mmapSlotHeader || binary.LittleEndian.Uint64(s[8:]) != pos {
+		return nil, false
+	}
+	record := s[mmapSlotHeader : mmapSlotHeader+n]
+	if crc32.ChecksumIEEE(record) != binary.LittleEndian.Uint32(s[4:]) {
+		return nil, false
+	}
+	return record, true
+}
+
+// MaxRecordSize returns the largest record a slot can hold.
+func (rb *MmapRingBuffer) MaxRecordSize() int {
+	return rb.slotSize - mmapSlotHeader
+}
+
+func (rb *MmapRingBuffer) Cap() int {
+	return int(rb.capacity)
+}
+
+func (rb *MmapRingBuffer) Size() int {
+	rb.mutex.Lock()
+	defer rb.mutex.Unlock()
+	return int(rb.tail - rb.head)
+}
+
+// Enqueue appends a copy of record. The slot is written before the tail is
+// advanced, so a crash in between loses at most this record.
+func (rb *MmapRingBuffer) Enqueue(record []byte) error {
+	if len(record) > rb.MaxRecordSize() {
+		return ErrRecordTooLarge
+	}
+	rb.mutex.Lock()
+	defer rb.mutex.Unlock()
+	if rb.data == nil {
+		return ErrRingBufferClosed
+	}
+	if rb.tail-rb.head == rb.capacity {
+		return ErrRingBufferFull
+	}
+
+	s := rb.slot(rb.tail)
+	copy(s[mmapSlotHeader:], record)
+	binary.LittleEndian.PutUint32(s[0:], uint32(len(record)))
+	binary.LittleEndian.PutUint32(s[4:], crc32.ChecksumIEEE(record))
+	binary.LittleEndian.PutUint64(s[8:], rb.tail)
+	if rb.syncWrites {
+		if err := msync(rb.data, rb.slotOffset(rb.tail), rb.slotSize); err != nil {
+			return err
+		}
+	}
+
+	rb.tail++
+	binary.LittleEndian.PutUint64(rb.data[hdrTail:], rb.tail)
+	return rb.syncHeader()
+}
+
+// Dequeue removes and returns the oldest record. A record whose checksum
+// no longer matches is removed too, so the queue keeps moving, but is
+// reported as ErrCorruptRecord instead of being returned.
+func (rb *MmapRingBuffer) Dequeue() ([]byte, bool, error) {
+	rb.mutex.Lock()
+	defer rb.mutex.Unlock()
+	if rb.data == nil {
+		return nil, false, ErrRingBufferClosed
+	}
+	record, ok, err := rb.peek()
+	if !ok && err == nil {
+		return nil, false, nil
+	}
+	rb.head++
+	binary.LittleEndian.PutUint64(rb.data[hdrHead:], rb.head)
+	return record, ok, errors.Join(err, rb.syncHeader())
+}
+
+// Peek returns the oldest record without removing it, or ErrCorruptRecord
+// if it fails its checksum.
+func (rb *MmapRingBuffer) Peek() ([]byte, bool, error) {
+	rb.mutex.Lock()
+	defer rb.mutex.Unlock()
+	return rb.peek()
+}
+
+func (rb *MmapRingBuffer) peek() ([]byte, bool, error) {
+	if rb.data == nil || rb.head == rb.tail {
+		return nil, false, nil
+	}
+	record, ok := rb.readSlot(rb.head)
+	if !ok {
+		return nil, false, fmt.Errorf("%w at position %d", ErrCorruptRecord, rb.head)
+	}
+	return append([]byte(nil), record...), true, nil
+}
+
+func (rb *MmapRingBuffer) syncHeader() error {
+	if !rb.syncWrites {
+		return nil
+	}
+	return msync(rb.data, 0, mmapHeaderSize)
+}
+
+// Sync flushes the whole mapping to disk.
+func (rb *MmapRingBuffer) Sync() error {
+	return msync(rb.data, 0, len(rb.data))
+}
+
+func (rb *MmapRingBuffer) Close() error {
+	rb.mutex.Lock()
+	defer rb.mutex.Unlock()
+	if rb.data == nil {
+		return nil
+	}
+	err := errors.Join(msync(rb.data, 0, len(rb.data)), munmap(rb.data), rb.file.Close())
+	rb.data = nil
+	return err
+}
@@ -0,0 +1,143 @@
+//go:build linux || darwin
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestMmapRingBufferPersists(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "queue")
+	opts := MmapRingBufferOptions{Capacity: 4, MaxRecordSize: 32}
+
+	rb, err := OpenMmapRingBuffer(path, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Run the positions past the capacity so the reopened queue wraps.
+	for i := 0; i < 7; i++ {
+		if err := rb.Enqueue([]byte(fmt.Sprintf("record-%d", i))); err != nil {
+			t.Fatalf("Enqueue(%d) error = %v", i, err)
+		}
+		if i < 3 {
+			rb.Dequeue()
+		}
+	}
+	if err := rb.Enqueue([]byte("overflow")); !errors.Is(err, ErrRingBufferFull) {
+		t.Errorf("Enqueue() on a full queue error = %v, want %v", err, ErrRingBufferFull)
+	}
+	if err := rb.Enqueue(make([]byte, 33)); !errors.Is(err, ErrRecordTooLarge) {
+		t.Errorf("Enqueue() of an oversized record error = %v, want %v", err, ErrRecordTooLarge)
+	}
+	if err := rb.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// A different layout is ignored for an existing file.
+	rb, err = OpenMmapRingBuffer(path, MmapRingBufferOptions{Capacity: 64})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rb.Close()
+	if rb.Cap() != 4 || rb.Size() != 4 {
+		t.Fatalf("reopened Cap(), Size() = %d, %d, want 4, 4", rb.Cap(), rb.Size())
+	}
+	for i := 3; i < 7; i++ {
+		record, ok, err := rb.Dequeue()
+		if want := fmt.Sprintf("record-%d", i); err != nil || !ok || string(record) != want {
+			t.Errorf("Dequeue() = (%q, %v, %v), want (%q, true, nil)", record, ok, err, want)
+		}
+	}
+	if _, ok, _ := rb.Dequeue(); ok {
+		t.Error("Dequeue() on an empty queue succeeded")
+	}
+}
+
+func TestMmapRingBufferRecovery(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "queue")
+	rb, err := OpenMmapRingBuffer(path, MmapRingBufferOptions{Capacity: 8, MaxRecordSize: 16, SyncWrites: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, s := range []string{"a", "b", "c"} {
+		if err := rb.Enqueue([]byte(s)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	last := rb.slotOffset(2)
+	rb.Close()
+
+	// Simulate a crash mid-way through writing "c": its payload no longer
+	// matches the checksum, and the stored tail was lost.
+	f, err := os.OpenFile(path, os.O_RDWR, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.WriteAt([]byte("x"), int64(last+mmapSlotHeader))
+	f.WriteAt(make([]byte, 8), hdrTail)
+	f.Close()
+
+	rb, err = OpenMmapRingBuffer(path, MmapRingBufferOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rb.Close()
+	if rb.Size() != 2 {
+		t.Fatalf("recovered Size() = %d, want 2", rb.Size())
+	}
+	if record, ok, err := rb.Peek(); !ok || err != nil || string(record) != "a" {
+		t.Errorf("Peek() = (%q, %v, %v), want (\"a\", true, nil)", record, ok, err)
+	}
+}
+
+func TestMmapRingBufferCorruptRecord(t *testing.T) {
+	rb, err := OpenMmapRingBuffer(filepath.Join(t.TempDir(), "queue"), MmapRingBufferOptions{Capacity: 4, MaxRecordSize: 16})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rb.Close()
+	rb.Enqueue([]byte("first"))
+	rb.Enqueue([]byte("second"))
+
+	// Flip a payload byte of the first record behind the queue's back.
+	rb.slot(0)[mmapSlotHeader] ^= 0xff
+
+	if _, ok, err := rb.Peek(); ok || !errors.Is(err, ErrCorruptRecord) {
+		t.Errorf("Peek() = (_, %v, %v), want (_, false, %v)", ok, err, ErrCorruptRecord)
+	}
+	if record, ok, err := rb.Dequeue(); ok || record != nil || !errors.Is(err, ErrCorruptRecord) {
+		t.Errorf("Dequeue() = (%q, %v, %v), want (nil, false, %v)", record, ok, err, ErrCorruptRecord)
+	}
+	if record, ok, err := rb.Dequeue(); !ok || err != nil || string(record) != "second" {
+		t.Errorf("Dequeue() after the corrupt record = (%q, %v, %v), want (\"second\", true, nil)", record, ok, err)
+	}
+}
+
+func TestMmapRingBufferTruncatedFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "queue")
+	rb, err := OpenMmapRingBuffer(path, MmapRingBufferOptions{Capacity: 64, MaxRecordSize: 64})
+	if err != nil {
+		t.Fatal(err)
+	}
+	rb.Close()
+	if err := os.Truncate(path, mmapHeaderSize+100); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := OpenMmapRingBuffer(path, MmapRingBufferOptions{}); !errors.Is(err, ErrCorruptHeader) {
+		t.Errorf("OpenMmapRingBuffer() of a truncated file error = %v, want %v", err, ErrCorruptHeader)
+	}
+}
+
+func TestMmapRingBufferCorruptHeader(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "queue")
+	if err := os.WriteFile(path, []byte("not a ring buffer file at all, just text"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := OpenMmapRingBuffer(path, MmapRingBufferOptions{}); !errors.Is(err, ErrCorruptHeader) {
+		t.Errorf("OpenMmapRingBuffer() error = %v, want %v", err, ErrCorruptHeader)
+	}
+}
@@ -0,0 +1,136 @@
+package main
+
+import (
+	"sync/atomic"
+)
+
+// cacheLineSize is the padding used to keep hot counters on separate cache
+// lines so producers and consumers do not invalidate each other's lines.
+const cacheLineSize = 64
+
+type mpmcSlot[T any] struct {
+	// seq says who may use the slot next: seq == pos means a producer
+	// claiming position pos may write it, seq == pos+1 means a consumer
+	// claiming pos may read it.
+	seq   atomic.Uint64
+	value T
+}
+
+// MPMCRingBuffer is a bounded lock-free queue that any number of goroutines
+// may produce to and consume from, following Dmitry Vyukov's design: each
+// slot carries a sequence number, and producers and consumers claim
+// positions with a CAS on their own counter and then hand the slot over by
+// publishing the next sequence.
+//
+// It offers the same API as RingBuffer except Peek, which cannot be made
+// meaningful with several consumers. Batch operations are not atomic; other
+// goroutines' items may be interleaved with a batch.
+type MPMCRingBuffer[T any] struct {
+	_     [cacheLineSize]byte
+	write atomic.Uint64
+	_     [cacheLineSize - 8]byte
+	read  atomic.Uint64
+	_     [cacheLineSize - 8]byte
+	slots []mpmcSlot[T]
+	mask  uint64
+}
+
+// NewMPMCRingBuffer creates a queue holding at least capacity items. The
+// capacity is rounded up to the next power of two.
+func NewMPMCRingBuffer[T any](capacity int) *MPMCRingBuffer[T] {
+	size := roundUpPow2(capacity)
+	q := &MPMCRingBuffer[T]{
+		slots: make([]mpmcSlot[T], size),
+		mask:  uint64(size - 1),
+	}
+	for i := range q.slots {
+		q.slots[i].seq.Store(uint64(i))
+	}
+	return q
+}
+
+func (q *MPMCRingBuffer[T]) Cap() int {
+	return len(q.slots)
+}
+
+func (q *MPMCRingBuffer[T]) Enqueue(value T) bool {
+	pos := q.write.Load()
+	for {
+		slot := &q.slots[pos&q.mask]
+		seq := slot.seq.Load()
+		switch diff := int64(seq - pos); {
+		case diff == 0:
+			if q.write.CompareAndSwap(pos, pos+1) {
+				slot.value = value
+				slot.seq.Store(pos + 1)
+				return true
+			}
+			pos = q.write.Load()
+		case diff < 0:
+			// The slot still holds the item from one lap ago.
+			return false
+		default:
+			// Another producer claimed pos; catch up.
+			pos = q.write.Load()
+		}
+	}
+}
+
+func (q *MPMCRingBuffer[T]) Dequeue() (T, bool) {
+	var zero T
+	pos := q.read.Load()
+	for {
+		slot := &q.slots[pos&q.mask]
+		seq := slot.seq.Load()
+		switch diff := int64(seq - (pos + 1)); {
+		case diff == 0:
+			if q.read.CompareAndSwap(pos, pos+1) {
+				value := slot.value
+				slot.value = zero
+				slot.seq.Store(pos + q.mask + 1)
+				return value, true
+			}
+			pos = q.read.Load()
+		case diff < 0:
+			return zero, false
+		default:
+			pos = q.read.Load()
+		}
+	}
+}
+
+func (q *MPMCRingBuffer[T]) EnqueueBatch(values []T) int {
+	for i, v := range values {
+		if !q.Enqueue(v) {
+			return i
+		}
+	}
+	return len(values)
+}
+
+func (q *MPMCRingBuffer[T]) DequeueBatch(dst []T) int {
+	for i := range dst {
+		v, ok := q.Dequeue()
+		if !ok {
+			return i
+		}
+		dst[i] = v
+	}
+	return len(dst)
+}
+
+// Size returns the number of claimed positions, which may briefly include
+// items still being written or read.
+func (q *MPMCRingBuffer[T]) Size() int {
+	readPos := q.read.Load()
+	n := int(q.write.Load() - readPos)
+	return min(n, len(q.slots))
+}
+
+func (q *MPMCRingBuffer[T]) IsEmpty() bool {
+	return q.Size() == 0
+}
+
+func (q *MPMCRingBuffer[T]) IsFull() bool {
+	return q.Size() == len(q.slots)
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"runtime"
+	"sync"
+	"sync/atomic"
+	"testing"
+)
+
+func TestMPMCRingBufferSequential(t *testing.T) {
+	q := NewMPMCRingBuffer[int](3)
+	if q.Cap() != 4 {
+		t.Fatalf("Cap() = %d, want 4", q.Cap())
+	}
+	for round := 0; round < 3; round++ {
+		if n := q.EnqueueBatch([]int{1, 2, 3, 4, 5}); n != 4 {
+			t.Fatalf("EnqueueBatch() = %d, want 4", n)
+		}
+		if !q.IsFull() {
+			t.Error("IsFull() = false after filling the queue")
+		}
+		out := make([]int, 5)
+		if n := q.DequeueBatch(out); n != 4 || out[0] != 1 || out[3] != 4 {
+			t.Errorf("DequeueBatch() = %d %v, want 4 [1 2 3 4]", n, out[:n])
+		}
+		if _, ok := q.Dequeue(); ok {
+			t.Error("Dequeue() on an empty queue succeeded")
+		}
+	}
+}
+
+// TestMPMCRingBufferConcurrent has several producers and consumers move
+// distinct values through a small queue and checks each arrives exactly once.
+func TestMPMCRingBufferConcurrent(t *testing.T) {
+	const producers, consumers, perProducer = 4, 4, 20_000
+	q := NewMPMCRingBuffer[int](16)
+
+	var wg sync.WaitGroup
+	for p := 0; p < producers; p++ {
+		wg.Add(1)
+		go func(p int) {
+			defer wg.Done()
+			for i := 0; i < perProducer; i++ {
+				for !q.Enqueue(p*perProducer + i) {
+					runtime.Gosched()
+				}
+			}
+		}(p)
+	}
+
+	seen := make([]atomic.Int32, producers*perProducer)
+	var received atomic.Int64
+	var cwg sync.WaitGroup
+	for c := 0; c < consumers; c++ {
+		cwg.Add(1)
+		go func() {
+			defer cwg.Done()
+			for received.Load() < producers*perProducer {
+				v, ok := q.Dequeue()
+				if !ok {
+					runtime.Gosched()
+					continue
+				}
+				seen[v].Add(1)
+				received.Add(1)
+			}
+		}()
+	}
+	wg.Wait()
+	cwg.Wait()
+
+	for v := range seen {
+		if n := seen[v].Load(); n != 1 {
+			t.Fatalf("value %d received %d times, want 1", v, n)
+		}
+	}
+}
+
+func BenchmarkMPMCRingBuffer(b *testing.B) {
+	q := NewMPMCRingBuffer[int](1024)
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			for !q.Enqueue(1) {
+				runtime.Gosched()
+			}
+			for {
+				if _, ok := q.Dequeue(); ok {
+					break
+				}
+				runtime.Gosched()
+			}
+		}
+	})
+}
+
+func BenchmarkBufferedChannel(b *testing.B) {
+	ch := make(chan int, 1024)
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			ch <- 1
+			<-ch
+		}
+	})
+}
+
+func BenchmarkMutexRingBuffer(b *testing.B) {
+	var mu sync.Mutex
+	rb := NewRingBuffer[int](1024)
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			mu.Lock()
+			rb.Enqueue(1)
+			mu.Unlock()
+			mu.Lock()
+			rb.Dequeue()
+			mu.Unlock()
+		}
+	})
+}
@@ -0,0 +1,179 @@
+package main
+
+import (
+	"sync"
+	"time"
+)
+
+// OverwriteRingBuffer keeps the most recent items: Enqueue on a full buffer
+// evicts the oldest item instead of failing. Evicting means the producer
+// moves the read position, which the lock-free SPSC RingBuffer cannot allow,
+// so this variant is guarded by a mutex and safe for any number of
+// goroutines.
+type OverwriteRingBuffer[T any] struct {
+	buffer  []T
+	mask    uint64
+	read    uint64
+	write   uint64
+	dropped uint64
+	mutex   sync.Mutex
+}
+
+// NewOverwriteRingBuffer creates a buffer keeping at least the last capacity
+// items. The capacity is rounded up to the next power of two.
+func NewOverwriteRingBuffer[T any](capacity int) *OverwriteRingBuffer[T] {
+	size := roundUpPow2(capacity)
+	return &OverwriteRingBuffer[T]{
+		buffer: make([]T, size),
+		mask:   uint64(size - 1),
+	}
+}
+
+func (rb *OverwriteRingBuffer[T]) Cap() int {
+	return len(rb.buffer)
+}
+
+// Enqueue adds value and reports whether the oldest item was evicted to make
+// room for it.
+func (rb *OverwriteRingBuffer[T]) Enqueue(value T) (evicted bool) {
+	rb.mutex.Lock()
+	defer rb.mutex.Unlock()
+	if rb.write-rb.read == uint64(len(rb.buffer)) {
+		rb.read++
+		rb.dropped++
+		evicted = true
+	}
+	rb.buffer[rb.write&rb.mask] = value
+	rb.write++
+	return evicted
+}
+
+func (rb *OverwriteRingBuffer[T]) Dequeue() (T, bool) {
+	rb.mutex.Lock()
+	defer rb.mutex.Unlock()
+	var zero T
+	if rb.read == rb.write {
+		return zero, false
+	}
+	slot := &rb.buffer[rb.read&rb.mask]
+	value := *slot
+	*slot = zero
+	rb.read++
+	return value, true
+}
+
+// Snapshot returns a copy of the buffered items, oldest first, without
+// removing them.
+func (rb *OverwriteRingBuffer[T]) Snapshot() []T {
+	rb.mutex.Lock()
+	defer rb.mutex.Unlock()
+	items := make([]T, 0, rb.write-rb.read)
+	for pos := rb.read; pos != rb.write; pos++ {
+		items = append(items, rb.buffer[pos&rb.mask])
+	}
+	return items
+}
+
+func (rb *OverwriteRingBuffer[T]) Size() int {
+	rb.mutex.Lock()
+	defer rb.mutex.Unlock()
+	return int(rb.write - rb.read)
+}
+
+// Dropped returns how many items have been evicted by Enqueue.
+func (rb *OverwriteRingBuffer[T]) Dropped() uint64 {
+	rb.mutex.Lock()
+	defer rb.mutex.Unlock()
+	return rb.dropped
+}
+
+type windowBucket struct {
+	index int64 // which bucketWidth-sized slice of time the count belongs to
+	count int64
+}
+
+// WindowedCounter counts events over a rolling window split into fixed
+// buckets, e.g. requests over the last minute in one-second buckets. Buckets
+// are a ring indexed by time, so an old bucket is reset lazily when its slot
+// comes round again and no background goroutine is needed.
+type WindowedCounter struct {
+	buckets     []windowBucket
+	bucketWidth time.Duration
+	clock       Clock
+	mutex       sync.Mutex
+}
+
+// NewWindowedCounter creates a counter over window divided into buckets
+// buckets. A nil clock uses the wall clock. It panics unless buckets is
+// positive and window is at least buckets nanoseconds, so that every bucket
+// has a non-zero width.
+func NewWindowedCounter(window time.Duration, buckets int, clock Clock) *WindowedCounter {
+	if buckets <= 0 {
+		panic("NewWindowedCounter: non-positive bucket count")
+	}
+	if window < time.Duration(buckets) {
+		panic("NewWindowedCounter: window shorter than one nanosecond per bucket")
+	}
+	if clock == nil {
+		clock = realClock{}
+	}
+	return &WindowedCounter{
+		buckets:     make([]windowBucket, buckets),
+		bucketWidth: window / time.Duration(buckets),
+		clock:       clock,
+	}
+}
+
+func (wc *WindowedCounter) currentIndex() int64 {
+	return wc.clock.Now().UnixNano() / int64(wc.bucketWidth)
+}
+
+// slot returns the bucket for idx, which is negative for times before 1970.
+func (wc *WindowedCounter) slot(idx int64) *windowBucket {
+	n := int64(len(wc.buckets))
+	return &wc.buckets[(idx%n+n)%n]
+}
+
+// Add records n events at the current time.
+func (wc *WindowedCounter) Add(n int64) {
+	wc.mutex.Lock()
+	defer wc.mutex.Unlock()
+	idx := wc.currentIndex()
+	b := wc.slot(idx)
+	if b.index != idx {
+		*b = windowBucket{index: idx}
+	}
+	b.count += n
+}
+
+// Buckets returns the count of every bucket in the window, oldest first. The
+// last entry is the bucket currently being filled.
+func (wc *WindowedCounter) Buckets() []int64 {
+	wc.mutex.Lock()
+	defer wc.mutex.Unlock()
+	n := int64(len(wc.buckets))
+	now := wc.currentIndex()
+	counts := make([]int64, n)
+	for i := int64(0); i < n; i++ {
+		idx := now - n + 1 + i
+		if b := wc.slot(idx); b.index == idx {
+			counts[i] = b.count
+		}
+	}
+	return counts
+}
+
+// Sum returns the number of events in the window.
+func (wc *WindowedCounter) Sum() int64 {
+	var sum int64
+	for _, c := range wc.Buckets() {
+		sum += c
+	}
+	return sum
+}
+
+// Rate returns the average events per second over the window.
+func (wc *WindowedCounter) Rate() float64 {
+	window := wc.bucketWidth * time.Duration(len(wc.buckets))
+	return float64(wc.Sum()) / window.Seconds()
+}
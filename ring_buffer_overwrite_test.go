@@ -0,0 +1,100 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestOverwriteRingBuffer(t *testing.T) {
+	rb := NewOverwriteRingBuffer[int](4)
+	for i := 1; i <= 6; i++ {
+		evicted := rb.Enqueue(i)
+		if want := i > 4; evicted != want {
+			t.Errorf("Enqueue(%d) evicted = %v, want %v", i, evicted, want)
+		}
+	}
+
+	if got := rb.Snapshot(); len(got) != 4 || got[0] != 3 || got[3] != 6 {
+		t.Errorf("Snapshot() = %v, want [3 4 5 6]", got)
+	}
+	if got := rb.Dropped(); got != 2 {
+		t.Errorf("Dropped() = %d, want 2", got)
+	}
+	if v, ok := rb.Dequeue(); !ok || v != 3 {
+		t.Errorf("Dequeue() = (%d, %v), want (3, true)", v, ok)
+	}
+	if got := rb.Size(); got != 3 {
+		t.Errorf("Size() = %d, want 3", got)
+	}
+}
+
+func TestWindowedCounter(t *testing.T) {
+	clock := newFakeClock()
+	wc := NewWindowedCounter(time.Minute, 60, clock)
+
+	tests := []struct {
+		name    string
+		advance time.Duration
+		add     int64
+		wantSum int64
+	}{
+		{name: "first second", add: 10, wantSum: 10},
+		{name: "same bucket", add: 5, wantSum: 15},
+		{name: "next second", advance: time.Second, add: 3, wantSum: 18},
+		{name: "first bucket about to expire", advance: 58 * time.Second, wantSum: 18},
+		{name: "first bucket expired", advance: time.Second, wantSum: 3},
+		{name: "window fully expired", advance: 2 * time.Minute, wantSum: 0},
+		{name: "reused slot starts at zero", add: 1, wantSum: 1},
+	}
+
+	for _, tt := range tests {
+		clock.Advance(tt.advance)
+		if tt.add > 0 {
+			wc.Add(tt.add)
+		}
+		if got := wc.Sum(); got != tt.wantSum {
+			t.Errorf("%s: Sum() = %d, want %d", tt.name, got, tt.wantSum)
+		}
+	}
+
+	if got := wc.Rate(); got != 1.0/60 {
+		t.Errorf("Rate() = %v, want %v", got, 1.0/60)
+	}
+	buckets := wc.Buckets()
+	if len(buckets) != 60 || buckets[59] != 1 {
+		t.Errorf("Buckets() = %v, want 60 buckets ending in 1", buckets)
+	}
+}
+
+func TestWindowedCounterBeforeEpoch(t *testing.T) {
+	clock := &fakeClock{now: time.Unix(-90, 0)}
+	wc := NewWindowedCounter(time.Minute, 60, clock)
+	wc.Add(2)
+	clock.Advance(time.Second)
+	wc.Add(1)
+	if got := wc.Sum(); got != 3 {
+		t.Errorf("Sum() = %d, want 3", got)
+	}
+}
+
+func TestNewWindowedCounterRejectsBadBuckets(t *testing.T) {
+	tests := []struct {
+		name    string
+		window  time.Duration
+		buckets int
+	}{
+		{name: "zero buckets", window: time.Minute, buckets: 0},
+		{name: "negative buckets", window: time.Minute, buckets: -1},
+		{name: "window shorter than bucket count", window: 10, buckets: 60},
+	}
+	for _, tt := range tests {
+		func() {
+			defer func() {
+				if recover() == nil {
+					t.Errorf("%s: NewWindowedCounter did not panic", tt.name)
+				}
+			}()
+			NewWindowedCounter(tt.window, tt.buckets, nil)
+		}()
+	}
+}
@@ -0,0 +1,218 @@
+package main
+
+import (
+	"context"
+	"runtime"
+	"testing"
+	"time"
+)
+
+func TestRingBufferCapacity(t *testing.T) {
+	tests := []struct {
+		requested int
+		want      int
+	}{
+		{0, 1},
+		{1, 1},
+		{4, 4},
+		{5, 8},
+		{1000, 1024},
+	}
+
+	for _, tt := range tests {
+		rb := NewRingBuffer[int](tt.requested)
+		if got := rb.Cap(); got != tt.want {
+			t.Errorf("NewRingBuffer(%d).Cap() = %d, want %d", tt.requested, got, tt.want)
+		}
+		n := 0
+		for rb.Enqueue(n) {
+			n++
+		}
+		if n != tt.want {
+			t.Errorf("NewRingBuffer(%d) held %d items, want %d", tt.requested, n, tt.want)
+		}
+	}
+}
+
+func TestRingBufferWrapAround(t *testing.T) {
+	rb := NewRingBuffer[string](4)
+	for round := 0; round < 3; round++ {
+		in := []string{"a", "b", "c", "d", "e"}
+		if n := rb.EnqueueBatch(in); n != 4 {
+			t.Fatalf("EnqueueBatch() = %d, want 4", n)
+		}
+		if !rb.IsFull() {
+			t.Fatal("IsFull() = false after filling the buffer")
+		}
+		if v, ok := rb.Peek(); !ok || v != "a" {
+			t.Errorf("Peek() = (%q, %v), want (\"a\", true)", v, ok)
+		}
+		if v, _ := rb.Dequeue(); v != "a" {
+			t.Errorf("Dequeue() = %q, want \"a\"", v)
+		}
+
+		out := make([]string, 8)
+		if n := rb.DequeueBatch(out); n != 3 || out[0] != "b" || out[2] != "d" {
+			t.Errorf("DequeueBatch() = %d %v, want 3 [b c d]", n, out[:n])
+		}
+		if !rb.IsEmpty() {
+			t.Fatal("IsEmpty() = false after draining the buffer")
+		}
+		if _, ok := rb.Dequeue(); ok {
+			t.Error("Dequeue() on an empty buffer succeeded")
+		}
+	}
+}
+
+// TestRingBufferSPSC checks ordering and, under -race, the memory ordering
+// of the single-producer/single-consumer contract.
+func TestRingBufferSPSC(t *testing.T) {
+	const total = 200_000
+	rb := NewRingBuffer[*int](64)
+
+	go func() {
+		batch := make([]*int, 0, 7)
+		for i := 0; i < total; {
+			batch = batch[:0]
+			for j := 0; j < cap(batch) && i+j < total; j++ {
+				v := i + j
+				batch = append(batch, &v)
+			}
+			sent := rb.EnqueueBatch(batch)
+			i += sent
+			if sent == 0 {
+				runtime.Gosched()
+			}
+		}
+	}()
+
+	buf := make([]*int, 5)
+	for next := 0; next < total; {
+		n := rb.DequeueBatch(buf)
+		if n == 0 {
+			runtime.Gosched()
+			continue
+		}
+		for _, v := range buf[:n] {
+			if *v != next {
+				t.Fatalf("got %d, want %d", *v, next)
+			}
+			next++
+		}
+	}
+}
+
+func TestRingBufferPutTake(t *testing.T) {
+	rb := NewRingBuffer[int](2)
+	ctx := context.Background()
+
+	go func() {
+		for i := 0; i < 1000; i++ {
+			if err := rb.Put(ctx, i); err != nil {
+				t.Errorf("Put(%d) error = %v", i, err)
+				return
+			}
+			if i%100 == 0 {
+				time.Sleep(time.Millisecond) // let the consumer park
+			}
+		}
+		rb.Close()
+	}()
+
+	for want := 0; ; want++ {
+		v, err := rb.Take(ctx)
+		if err == ErrRingBufferClosed {
+			if want != 1000 {
+				t.Errorf("Take() returned ErrRingBufferClosed after %d items, want 1000", want)
+			}
+			return
+		}
+		if err != nil || v != want {
+			t.Fatalf("Take() = (%d, %v), want (%d, nil)", v, err, want)
+		}
+	}
+}
+
+func TestRingBufferBlockingCancellation(t *testing.T) {
+	tests := []struct {
+		name string
+		op   func(ctx context.Context, rb *RingBuffer[int]) error
+	}{
+		{
+			name: "put on full buffer",
+			op: func(ctx context.Context, rb *RingBuffer[int]) error {
+				rb.Enqueue(1)
+				return rb.Put(ctx, 2)
+			},
+		},
+		{
+			name: "take on empty buffer",
+			op: func(ctx context.Context, rb *RingBuffer[int]) error {
+				_, err := rb.Take(ctx)
+				return err
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+			defer cancel()
+			if err := tt.op(ctx, NewRingBuffer[int](1)); err != context.DeadlineExceeded {
+				t.Errorf("error = %v, want %v", err, context.DeadlineExceeded)
+			}
+		})
+
+		t.Run(tt.name+" closed", func(t *testing.T) {
+			rb := NewRingBuffer[int](1)
+			go func() {
+				time.Sleep(10 * time.Millisecond)
+				rb.Close()
+			}()
+			if err := tt.op(context.Background(), rb); err != ErrRingBufferClosed {
+				t.Errorf("error = %v, want %v", err, ErrRingBufferClosed)
+			}
+		})
+	}
+}
+
+func TestRingBufferDrain(t *testing.T) {
+	rb := NewRingBuffer[int](8)
+	rb.EnqueueBatch([]int{1, 2, 3})
+	rb.Close()
+
+	if rb.Enqueue(4) {
+		t.Error("Enqueue() after Close succeeded")
+	}
+	if got := rb.Drain(); len(got) != 3 || got[0] != 1 || got[2] != 3 {
+		t.Errorf("Drain() = %v, want [1 2 3]", got)
+	}
+	if _, err := rb.Take(context.Background()); err != ErrRingBufferClosed {
+		t.Errorf("Take() on a drained, closed buffer error = %v, want %v", err, ErrRingBufferClosed)
+	}
+}
+
+func BenchmarkRingBufferPutTake(b *testing.B) {
+	rb := NewRingBuffer[int](1024)
+	ctx := context.Background()
+	go func() {
+		for i := 0; i < b.N; i++ {
+			rb.Put(ctx, i)
+		}
+	}()
+	for i := 0; i < b.N; i++ {
+		rb.Take(ctx)
+	}
+}
+
+func BenchmarkChannelSendReceive(b *testing.B) {
+	ch := make(chan int, 1024)
+	go func() {
+		for i := 0; i < b.N; i++ {
+			ch <- i
+		}
+	}()
+	for i := 0; i < b.N; i++ {
+		<-ch
+	}
+}
@@ -0,0 +1,282 @@
+package main
+
+import (
+	"cmp"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"slices"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// GatewayOptions configures a Gateway.
+type GatewayOptions struct {
+	// HistorySize is how many recent messages are kept for clients that
+	// reconnect with a last event ID. Default 1024.
+	HistorySize int
+	// QueueSize bounds each connection's subscriber queue; the oldest
+	// messages are dropped when a client falls behind. Default 256.
+	QueueSize int
+	// Rate and Burst configure the token bucket applied to the events sent
+	// on each connection. A zero Rate disables per-connection limiting.
+	Rate  float64
+	Burst int
+	// KeepAlive is the interval of SSE comments / WebSocket pings sent on
+	// idle connections. Default 15s.
+	KeepAlive time.Duration
+}
+
+// Gateway exposes broker subscriptions to HTTP clients over Server-Sent
+// Events or WebSocket. Each connection becomes a component in the broker's
+// subscription registry, identified by the "component" query parameter or a
+// generated UID, and subscribes to the pattern in the "topic" parameter.
+// Both transports send every message as a JSON object with its id, topic
+// and data.
+type Gateway struct {
+	broker        *Broker
+	opts          GatewayOptions
+	history       *eventHistory
+	removeHistory func()
+	connID        atomic.Uint64
+}
+
+func NewGateway(b *Broker, opts GatewayOptions) *Gateway {
+	if opts.HistorySize <= 0 {
+		opts.HistorySize = 1024
+	}
+	if opts.QueueSize <= 0 {
+		opts.QueueSize = 256
+	}
+	if opts.KeepAlive <= 0 {
+		opts.KeepAlive = 15 * time.Second
+	}
+
+	g := &Gateway{broker: b, opts: opts, history: &eventHistory{size: opts.HistorySize}}
+	// History is recorded in the publish path rather than by a subscriber
+	// of its own, so a message is in history before Publish delivers it and
+	// a resuming client cannot fall into the gap between the two.
+	g.removeHistory = b.OnPublish(g.history.add)
+	return g
+}
+
+// Close stops recording history. Open connections are not affected.
+func (g *Gateway) Close() error {
+	g.removeHistory()
+	return nil
+}
+
+func (g *Gateway) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	topic := r.URL.Query().Get("topic")
+	if topic == "" {
+		http.Error(w, "Missing topic parameter", http.StatusBadRequest)
+		return
+	}
+	component := r.URL.Query().Get("component")
+	if component == "" {
+		component = fmt.Sprintf("gateway-conn-%d", g.connID.Add(1))
+	}
+
+	if isWebSocketRequest(r) {
+		g.serveWebSocket(w, r, component, topic)
+	} else {
+		g.serveSSE(w, r, component, topic)
+	}
+}
+
+// lastEventID reads the resume position from the Last-Event-ID header that
+// EventSource sends on reconnect, or the last_event_id query parameter.
+func lastEventID(r *http.Request) uint64 {
+	v := r.Header.Get("Last-Event-ID")
+	if v == "" {
+		v = r.URL.Query().Get("last_event_id")
+	}
+	id, _ := strconv.ParseUint(v, 10, 64)
+	return id
+}
+
+func (g *Gateway) serveSSE(w http.ResponseWriter, r *http.Request, component, topic string) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "Streaming not supported", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	// Events are left unnamed so that EventSource.onmessage sees all of
+	// them whatever topic the pattern matched; the topic travels in the data.
+	send := func(m *Message) error {
+		b, err := json.Marshal(gatewayEvent{ID: m.ID, Topic: m.Topic, Data: string(m.Payload)})
+		if err != nil {
+			return err
+		}
+		_, err = fmt.Fprintf(w, "id: %d\ndata: %s\n\n", m.ID, b)
+		flusher.Flush()
+		return err
+	}
+	keepAlive := func() error {
+		_, err := fmt.Fprint(w, ": keep-alive\n\n")
+		flusher.Flush()
+		return err
+	}
+	g.stream(r.Context(), component, topic, lastEventID(r), send, keepAlive)
+}
+
+// gatewayEvent is the JSON form of a message sent to SSE and WebSocket
+// clients.
+type gatewayEvent struct {
+	ID    uint64 `json:"id"`
+	Topic string `json:"topic"`
+	Data  string `json:"data"`
+}
+
+func (g *Gateway) serveWebSocket(w http.ResponseWriter, r *http.Request, component, topic string) {
+	lastID := lastEventID(r)
+	conn, err := upgradeWebSocket(w, r)
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+
+	// The request context is not cancelled for hijacked connections, so
+	// watch the socket for a close frame or error instead.
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		defer cancel()
+		for {
+			if _, _, err := conn.ReadFrame(); err != nil {
+				return
+			}
+		}
+	}()
+
+	send := func(m *Message) error {
+		b, err := json.Marshal(gatewayEvent{ID: m.ID, Topic: m.Topic, Data: string(m.Payload)})
+		if err != nil {
+			return err
+		}
+		return conn.WriteText(b)
+	}
+	keepAlive := func() error {
+		return conn.writeFrame(wsOpPing, nil)
+	}
+	g.stream(ctx, component, topic, lastID, send, keepAlive)
+}
+
+// stream replays history after lastID and then forwards live messages until
+// ctx is done or a write fails. The live subscription is opened before the
+// replay so nothing published in between is missed; messages seen in both
+// are sent once.
+func (g *Gateway) stream(ctx context.Context, component, topic string, lastID uint64, send func(*Message) error, keepAlive func() error) {
+	sub := g.broker.Subscribe(component, topic, SubscriberOptions{
+		QueueSize: g.opts.QueueSize,
+		Policy:    DropOldest,
+	})
+	defer g.broker.Unsubscribe(sub)
+
+	var limiter *RateLimiter
+	if g.opts.Rate > 0 {
+		limiter = NewTokenBucket(g.opts.Rate, max(g.opts.Burst, 1), nil)
+	}
+	forward := func(m *Message) bool {
+		if limiter != nil && !waitForToken(ctx, limiter) {
+			return false
+		}
+		return send(m) == nil
+	}
+
+	replayed := make(map[uint64]bool)
+	if lastID > 0 {
+		for _, m := range g.history.since(lastID, topic) {
+			if !forward(&m) {
+				return
+			}
+			replayed[m.ID] = true
+		}
+	}
+
+	ticker := time.NewTicker(g.opts.KeepAlive)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case m, ok := <-sub.C:
+			if !ok {
+				return
+			}
+			if replayed[m.ID] {
+				delete(replayed, m.ID)
+				continue
+			}
+			if !forward(m) {
+				return
+			}
+		case <-ticker.C:
+			if keepAlive() != nil {
+				return
+			}
+		}
+	}
+}
+
+// waitForToken blocks until rl has a token or ctx is done.
+func waitForToken(ctx context.Context, rl *RateLimiter) bool {
+	for {
+		res := rl.Take()
+		if res.Allowed {
+			return true
+		}
+		timer := time.NewTimer(res.RetryAfter)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return false
+		case <-timer.C:
+		}
+	}
+}
+
+// eventHistory keeps the most recent messages across all topics, oldest
+// first. The slice is allowed to grow to twice its size before it is
+// compacted so that adding a message is amortized O(1).
+type eventHistory struct {
+	size     int
+	messages []Message
+	mutex    sync.Mutex
+}
+
+func (h *eventHistory) add(m Message) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	m.sub = nil
+	h.messages = append(h.messages, m)
+	if len(h.messages) >= 2*h.size {
+		h.messages = append(h.messages[:0], h.messages[len(h.messages)-h.size:]...)
+	}
+}
+
+// since returns the recorded messages after lastID matching pattern in ID
+// order. Concurrent publishers may record their messages slightly out of
+// order.
+func (h *eventHistory) since(lastID uint64, pattern string) []Message {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	recent := h.messages[max(len(h.messages)-h.size, 0):]
+	var out []Message
+	for _, m := range recent {
+		if m.ID > lastID && matchTopic(pattern, m.Topic) {
+			out = append(out, m)
+		}
+	}
+	slices.SortFunc(out, func(a, b Message) int { return cmp.Compare(a.ID, b.ID) })
+	return out
+}
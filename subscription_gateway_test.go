@@ -0,0 +1,196 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// waitForSubscribers polls until pattern has n components in the registry.
+func waitForSubscribers(t *testing.T, b *Broker, pattern string, n int) {
+	t.Helper()
+	deadline := time.Now().Add(time.Second)
+	for len(b.Registry().Components(pattern)) < n {
+		if time.Now().After(deadline) {
+			t.Fatalf("no subscriber on %q", pattern)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+// readSSEEvent reads the next event, which must be unnamed so that
+// EventSource.onmessage receives it, and decodes its JSON data.
+func readSSEEvent(t *testing.T, r *bufio.Reader) gatewayEvent {
+	t.Helper()
+	var id, data string
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			t.Fatalf("reading event stream: %v", err)
+		}
+		line = strings.TrimRight(line, "\n")
+		switch {
+		case line == "" && id != "":
+			var ev gatewayEvent
+			if err := json.Unmarshal([]byte(data), &ev); err != nil {
+				t.Fatalf("event data %q: %v", data, err)
+			}
+			if fmt.Sprint(ev.ID) != id {
+				t.Errorf("event id line %q does not match data id %d", id, ev.ID)
+			}
+			return ev
+		case strings.HasPrefix(line, "event: "):
+			t.Errorf("event named %q, want unnamed events", strings.TrimPrefix(line, "event: "))
+		case strings.HasPrefix(line, "id: "):
+			id = strings.TrimPrefix(line, "id: ")
+		case strings.HasPrefix(line, "data: "):
+			data += strings.TrimPrefix(line, "data: ")
+		}
+	}
+}
+
+func TestGatewaySSEResume(t *testing.T) {
+	b := NewBroker()
+	g := NewGateway(b, GatewayOptions{})
+	defer g.Close()
+	srv := httptest.NewServer(g)
+	defer srv.Close()
+
+	// History is recorded by Publish itself, so a client may resume right
+	// after the last publish without missing anything.
+	var ids []uint64
+	for i := 1; i <= 3; i++ {
+		id, _ := b.Publish(context.Background(), "orders.created", []byte(fmt.Sprint(i)))
+		ids = append(ids, id)
+	}
+	if got := len(g.history.since(0, ">")); got != 3 {
+		t.Fatalf("history holds %d messages right after publishing, want 3", got)
+	}
+
+	req, _ := http.NewRequest("GET", srv.URL+"/?topic=orders.*&component=ui-1", nil)
+	req.Header.Set("Last-Event-ID", fmt.Sprint(ids[0]))
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if ct := resp.Header.Get("Content-Type"); ct != "text/event-stream" {
+		t.Fatalf("Content-Type = %q, want text/event-stream", ct)
+	}
+	r := bufio.NewReader(resp.Body)
+
+	for _, want := range []string{"2", "3"} {
+		if ev := readSSEEvent(t, r); ev.Data != want || ev.Topic != "orders.created" {
+			t.Errorf("replayed event = %+v, want data %q on orders.created", ev, want)
+		}
+	}
+
+	waitForSubscribers(t, b, "orders.*", 1)
+	if got := b.Registry().Components("orders.*"); len(got) != 1 || got[0] != "ui-1" {
+		t.Errorf("registry components = %v, want [ui-1]", got)
+	}
+	b.Publish(context.Background(), "orders.created", []byte("live"))
+	if ev := readSSEEvent(t, r); ev.Data != "live" {
+		t.Errorf("live data = %q, want %q", ev.Data, "live")
+	}
+}
+
+func TestGatewayWebSocket(t *testing.T) {
+	b := NewBroker()
+	g := NewGateway(b, GatewayOptions{})
+	defer g.Close()
+	srv := httptest.NewServer(g)
+	defer srv.Close()
+
+	conn, err := net.Dial("tcp", strings.TrimPrefix(srv.URL, "http://"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	nonce := make([]byte, 16)
+	rand.Read(nonce)
+	key := base64.StdEncoding.EncodeToString(nonce)
+	fmt.Fprintf(conn, "GET /?topic=alerts HTTP/1.1\r\nHost: test\r\n"+
+		"Upgrade: websocket\r\nConnection: Upgrade\r\n"+
+		"Sec-WebSocket-Key: %s\r\nSec-WebSocket-Version: 13\r\n\r\n", key)
+
+	r := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(r, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != http.StatusSwitchingProtocols {
+		t.Fatalf("handshake status = %d, want 101", resp.StatusCode)
+	}
+	if got := resp.Header.Get("Sec-WebSocket-Accept"); got != websocketAccept(key) {
+		t.Errorf("Sec-WebSocket-Accept = %q, want %q", got, websocketAccept(key))
+	}
+
+	waitForSubscribers(t, b, "alerts", 1)
+	b.Publish(context.Background(), "alerts", []byte("disk full"))
+
+	f, err := readWebSocketFrame(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var ev gatewayEvent
+	if f.opcode != wsOpText || json.Unmarshal(f.payload, &ev) != nil || ev.Data != "disk full" {
+		t.Errorf("frame = (%d, %s), want text event with data %q", f.opcode, f.payload, "disk full")
+	}
+
+	writeWebSocketFrame(conn, wsOpClose, nil, []byte{1, 2, 3, 4})
+	deadline := time.Now().Add(time.Second)
+	for len(b.Registry().Components("alerts")) > 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("subscription not removed after close")
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestGatewayRateLimitsConnection(t *testing.T) {
+	b := NewBroker()
+	g := NewGateway(b, GatewayOptions{Rate: 20, Burst: 1})
+	defer g.Close()
+	srv := httptest.NewServer(g)
+	defer srv.Close()
+
+	var first uint64
+	for i := 0; i < 5; i++ {
+		id, _ := b.Publish(context.Background(), "metrics", []byte(fmt.Sprint(i)))
+		if i == 0 {
+			first = id
+		}
+	}
+
+	req, _ := http.NewRequest("GET", srv.URL+"/?topic=metrics", nil)
+	req.Header.Set("Last-Event-ID", fmt.Sprint(first))
+	start := time.Now()
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	r := bufio.NewReader(resp.Body)
+
+	// Four replayed events with a burst of one need three more tokens at
+	// 20 per second.
+	for i := 1; i < 5; i++ {
+		if ev := readSSEEvent(t, r); ev.Data != fmt.Sprint(i) {
+			t.Errorf("event %d data = %q, want %q", i, ev.Data, fmt.Sprint(i))
+		}
+	}
+	if elapsed := time.Since(start); elapsed < 140*time.Millisecond {
+		t.Errorf("4 events at 20/s with burst 1 arrived in %v, want at least 150ms", elapsed)
+	}
+}
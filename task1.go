@@ -1,6 +1,10 @@
 package main 
 
-import "fmt"
+import (
+	"context"
+	"fmt"
+	"time"
+)
 
 func main() {
 	tasks := []Task{
@@ -17,8 +21,15 @@ func main() {
 	wp := WorkerPool {
 		Tasks: tasks,
 		concurrency: 5,
+		TaskTimeout: 4 * time.Second,
 	}
 
-	wp.Run()
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+
+	if err := wp.Run(ctx); err != nil {
+		fmt.Println("Some tasks failed:", err)
+		return
+	}
 	fmt.Println("All tasks have completed!")
 }
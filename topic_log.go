@@ -0,0 +1,690 @@
+package main
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"hash/fnv"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+var (
+	ErrOffsetOutOfRange = errors.New("offset out of range")
+	ErrInvalidTopic     = errors.New("invalid topic name")
+	ErrInvalidPartition = errors.New("invalid partition")
+)
+
+// Record is one entry of a topic partition.
+type Record struct {
+	Partition int
+	Offset    uint64
+	Timestamp time.Time
+	Key       []byte
+	Payload   []byte
+}
+
+// TopicLogOptions configures a TopicLog. Zero values select the defaults.
+type TopicLogOptions struct {
+	Partitions     int           // per new topic, default 1
+	SegmentBytes   int64         // segment size before rolling, default 16 MiB
+	RetentionAge   time.Duration // delete segments older than this, zero keeps forever
+	RetentionBytes int64         // per partition, zero for unlimited
+	Clock          Clock
+
+	// SyncWrites fsyncs the segment file before Append returns. Without it
+	// appended records survive a crash of the process, since they are
+	// already in the page cache, but not a crash of the machine or a power
+	// failure.
+	SyncWrites bool
+}
+
+// TopicLog is an append-only, segment-file-backed log per topic. Each topic
+// is split into partitions, and each partition into segment files named
+// after the offset of their first record:
+//
+//	<dir>/<topic>/<partition>/<base offset>.log
+//	<dir>/<topic>/groups/<group>.offsets
+//
+// Records are framed as crc32 | length | timestamp | key length | key |
+// payload, so a write torn by a crash is detected and truncated on open.
+type TopicLog struct {
+	dir    string
+	opts   TopicLogOptions
+	topics map[string]*logTopic
+	mutex  sync.Mutex
+}
+
+type logTopic struct {
+	dir        string
+	partitions []*logPartition
+	nextRR     atomic.Uint64
+	groups     map[string]*ConsumerGroup
+}
+
+func OpenTopicLog(dir string, opts TopicLogOptions) (*TopicLog, error) {
+	if opts.Partitions <= 0 {
+		opts.Partitions = 1
+	}
+	if opts.SegmentBytes <= 0 {
+		opts.SegmentBytes = 16 << 20
+	}
+	if opts.Clock == nil {
+		opts.Clock = realClock{}
+	}
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+
+	l := &TopicLog{dir: dir, opts: opts, topics: make(map[string]*logTopic)}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	for _, e := range entries {
+		if e.IsDir() {
+			if _, err := l.topic(e.Name(), false); err != nil {
+				l.Close()
+				return nil, fmt.Errorf("opening topic %s: %w", e.Name(), err)
+			}
+		}
+	}
+	return l, nil
+}
+
+func validTopicName(name string) bool {
+	return name != "" && !strings.HasPrefix(name, ".") && !strings.ContainsAny(name, `/\`)
+}
+
+// topic returns the state for name, loading it from disk or, if create is
+// set, creating it with the configured number of partitions.
+func (l *TopicLog) topic(name string, create bool) (*logTopic, error) {
+	if !validTopicName(name) {
+		return nil, ErrInvalidTopic
+	}
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	if t, ok := l.topics[name]; ok {
+		return t, nil
+	}
+
+	t := &logTopic{dir: filepath.Join(l.dir, name), groups: make(map[string]*ConsumerGroup)}
+	n := 0
+	for {
+		if _, err := os.Stat(filepath.Join(t.dir, strconv.Itoa(n))); err != nil {
+			break
+		}
+		n++
+	}
+	if n == 0 {
+		if !create {
+			return nil, nil
+		}
+		n = l.opts.Partitions
+	}
+	for i := 0; i < n; i++ {
+		p, err := openPartition(filepath.Join(t.dir, strconv.Itoa(i)), i, l.opts)
+		if err != nil {
+			return nil, err
+		}
+		t.partitions = append(t.partitions, p)
+	}
+	l.topics[name] = t
+	return t, nil
+}
+
+// Partitions returns the number of partitions of topic, or zero if it does
+// not exist.
+func (l *TopicLog) Partitions(topic string) int {
+	t, err := l.topic(topic, false)
+	if err != nil || t == nil {
+		return 0
+	}
+	return len(t.partitions)
+}
+
+// Append writes a record and returns the partition and offset it was stored
+// at. Records with the same key always go to the same partition; records
+// without a key are spread round-robin.
+func (l *TopicLog) Append(topic string, key, payload []byte) (partition int, offset uint64, err error) {
+	t, err := l.topic(topic, true)
+	if err != nil {
+		return 0, 0, err
+	}
+	if len(key) > 0 {
+		h := fnv.New32()
+		h.Write(key)
+		partition = int(h.Sum32() % uint32(len(t.partitions)))
+	} else {
+		partition = int(t.nextRR.Add(1)-1) % len(t.partitions)
+	}
+	offset, err = t.partitions[partition].append(l.opts.Clock.Now(), key, payload)
+	return partition, offset, err
+}
+
+// Read returns up to max records of a partition starting at offset. Reading
+// at the end of the partition returns no records; reading before the oldest
+// retained record returns ErrOffsetOutOfRange.
+func (l *TopicLog) Read(topic string, partition int, offset uint64, max int) ([]Record, error) {
+	p, err := l.partition(topic, partition)
+	if err != nil {
+		return nil, err
+	}
+	return p.read(offset, max)
+}
+
+// Offsets returns the oldest retained offset of a partition and the offset
+// the next record will get.
+func (l *TopicLog) Offsets(topic string, partition int) (first, next uint64, err error) {
+	p, err := l.partition(topic, partition)
+	if err != nil {
+		return 0, 0, err
+	}
+	first, next = p.bounds()
+	return first, next, nil
+}
+
+func (l *TopicLog) partition(topic string, partition int) (*logPartition, error) {
+	t, err := l.topic(topic, false)
+	if err != nil {
+		return nil, err
+	}
+	if t == nil || partition < 0 || partition >= len(t.partitions) {
+		return nil, ErrInvalidPartition
+	}
+	return t.partitions[partition], nil
+}
+
+// ApplyRetention deletes segments that are past RetentionAge or exceed
+// RetentionBytes. The active segment of a partition is never deleted. It runs
+// automatically whenever a segment is rolled.
+func (l *TopicLog) ApplyRetention() error {
+	l.mutex.Lock()
+	var parts []*logPartition
+	for _, t := range l.topics {
+		parts = append(parts, t.partitions...)
+	}
+	l.mutex.Unlock()
+
+	var errs []error
+	for _, p := range parts {
+		if err := p.applyRetention(l.opts.Clock.Now()); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func (l *TopicLog) Close() error {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	var errs []error
+	for _, t := range l.topics {
+		for _, p := range t.partitions {
+			errs = append(errs, p.close())
+		}
+	}
+	return errors.Join(errs...)
+}
+
+const (
+	recordHeaderSize = 18 // crc32, length, timestamp, key length
+	maxRecordBytes   = 64 << 20
+)
+
+type logSegment struct {
+	base      uint64
+	file      *os.File
+	positions []int64 // file position of each record
+	size      int64
+	modTime   time.Time
+}
+
+type logPartition struct {
+	id       int
+	dir      string
+	opts     TopicLogOptions
+	segments []*logSegment
+	next     uint64
+	mutex    sync.RWMutex
+}
+
+func segmentName(base uint64) string {
+	return fmt.Sprintf("%020d.log", base)
+}
+
+func openPartition(dir string, id int, opts TopicLogOptions) (*logPartition, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+	p := &logPartition{id: id, dir: dir, opts: opts}
+
+	names, err := filepath.Glob(filepath.Join(dir, "*.log"))
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		base, err := strconv.ParseUint(strings.TrimSuffix(filepath.Base(name), ".log"), 10, 64)
+		if err != nil {
+			continue
+		}
+		seg, err := openSegment(name, base)
+		if err != nil {
+			p.close()
+			return nil, err
+		}
+		p.segments = append(p.segments, seg)
+	}
+
+	if len(p.segments) == 0 {
+		if err := p.roll(0); err != nil {
+			return nil, err
+		}
+	}
+	last := p.segments[len(p.segments)-1]
+	p.next = last.base + uint64(len(last.positions))
+	return p, nil
+}
+
+// openSegment indexes every intact record of a segment file and truncates
+// anything after the first incomplete or corrupt one.
+func openSegment(name string, base uint64) (*logSegment, error) {
+	f, err := os.OpenFile(name, os.O_RDWR, 0o644)
+	if err != nil {
+		return nil, err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	seg := &logSegment{base: base, file: f, modTime: info.ModTime()}
+
+	var header [recordHeaderSize]byte
+	for {
+		if _, err := f.ReadAt(header[:], seg.size); err != nil {
+			break
+		}
+		n := int64(binary.BigEndian.Uint32(header[4:8]))
+		// A length past the end of the file is a torn or corrupt header;
+		// checking it first keeps garbage from driving the allocation.
+		if n > maxRecordBytes || n > info.Size()-seg.size-recordHeaderSize ||
+			int64(binary.BigEndian.Uint16(header[16:18])) > n {
+			break
+		}
+		body := make([]byte, recordHeaderSize-8+n)
+		copy(body, header[8:])
+		if _, err := f.ReadAt(body[recordHeaderSize-8:], seg.size+recordHeaderSize); err != nil {
+			break
+		}
+		if crc32.ChecksumIEEE(body) != binary.BigEndian.Uint32(header[0:4]) {
+			break
+		}
+		seg.positions = append(seg.positions, seg.size)
+		seg.size += recordHeaderSize + n
+	}
+	if seg.size < info.Size() {
+		if err := f.Truncate(seg.size); err != nil {
+			f.Close()
+			return nil, err
+		}
+	}
+	return seg, nil
+}
+
+// roll starts a new segment at base. Callers hold p.mutex.
+func (p *logPartition) roll(base uint64) error {
+	f, err := os.OpenFile(filepath.Join(p.dir, segmentName(base)), os.O_RDWR|os.O_CREATE|os.O_EXCL, 0o644)
+	if err != nil {
+		return err
+	}
+	p.segments = append(p.segments, &logSegment{base: base, file: f, modTime: p.opts.Clock.Now()})
+	return nil
+}
+
+func (p *logPartition) append(ts time.Time, key, payload []byte) (uint64, error) {
+	if len(key) > 0xFFFF {
+		return 0, errors.New("record key too long")
+	}
+	if len(key)+len(payload) > maxRecordBytes {
+		return 0, fmt.Errorf("record larger than %d bytes", maxRecordBytes)
+	}
+	buf := make([]byte, recordHeaderSize+len(key)+len(payload))
+	binary.BigEndian.PutUint32(buf[4:8], uint32(len(key)+len(payload)))
+	binary.BigEndian.PutUint64(buf[8:16], uint64(ts.UnixNano()))
+	binary.BigEndian.PutUint16(buf[16:18], uint16(len(key)))
+	copy(buf[recordHeaderSize:], key)
+	copy(buf[recordHeaderSize+len(key):], payload)
+	binary.BigEndian.PutUint32(buf[0:4], crc32.ChecksumIEEE(buf[8:]))
+
+	p.mutex.Lock()
+	seg := p.segments[len(p.segments)-1]
+	rolled := false
+	if seg.size > 0 && seg.size+int64(len(buf)) > p.opts.SegmentBytes {
+		if err := p.roll(p.next); err != nil {
+			p.mutex.Unlock()
+			return 0, err
+		}
+		seg = p.segments[len(p.segments)-1]
+		rolled = true
+	}
+	if _, err := seg.file.WriteAt(buf, seg.size); err != nil {
+		p.mutex.Unlock()
+		return 0, err
+	}
+	if p.opts.SyncWrites {
+		if err := seg.file.Sync(); err != nil {
+			p.mutex.Unlock()
+			return 0, err
+		}
+	}
+	seg.positions = append(seg.positions, seg.size)
+	seg.size += int64(len(buf))
+	seg.modTime = ts
+	offset := p.next
+	p.next++
+	p.mutex.Unlock()
+
+	if rolled {
+		return offset, p.applyRetention(ts)
+	}
+	return offset, nil
+}
+
+func (p *logPartition) bounds() (first, next uint64) {
+	p.mutex.RLock()
+	defer p.mutex.RUnlock()
+	return p.segments[0].base, p.next
+}
+
+func (p *logPartition) read(offset uint64, max int) ([]Record, error) {
+	p.mutex.RLock()
+	defer p.mutex.RUnlock()
+
+	if offset < p.segments[0].base || offset > p.next {
+		return nil, ErrOffsetOutOfRange
+	}
+	i := sort.Search(len(p.segments), func(i int) bool {
+		return p.segments[i].base > offset
+	}) - 1
+
+	var records []Record
+	for ; i < len(p.segments) && len(records) < max; i++ {
+		seg := p.segments[i]
+		for j := int(offset - seg.base); j < len(seg.positions) && len(records) < max; j++ {
+			rec, err := seg.readAt(seg.positions[j])
+			if err != nil {
+				return records, err
+			}
+			rec.Partition = p.id
+			rec.Offset = seg.base + uint64(j)
+			records = append(records, rec)
+		}
+		if i+1 < len(p.segments) {
+			offset = p.segments[i+1].base
+		}
+	}
+	return records, nil
+}
+
+func (s *logSegment) readAt(pos int64) (Record, error) {
+	var header [recordHeaderSize]byte
+	if _, err := s.file.ReadAt(header[:], pos); err != nil {
+		return Record{}, err
+	}
+	n := binary.BigEndian.Uint32(header[4:8])
+	keyLen := binary.BigEndian.Uint16(header[16:18])
+	body := make([]byte, n)
+	if _, err := s.file.ReadAt(body, pos+recordHeaderSize); err != nil && err != io.EOF {
+		return Record{}, err
+	}
+	return Record{
+		Timestamp: time.Unix(0, int64(binary.BigEndian.Uint64(header[8:16]))),
+		Key:       body[:keyLen:keyLen],
+		Payload:   body[keyLen:],
+	}, nil
+}
+
+func (p *logPartition) applyRetention(now time.Time) error {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	var total int64
+	for _, s := range p.segments {
+		total += s.size
+	}
+	for len(p.segments) > 1 {
+		oldest := p.segments[0]
+		expired := p.opts.RetentionAge > 0 && now.Sub(oldest.modTime) > p.opts.RetentionAge
+		oversize := p.opts.RetentionBytes > 0 && total > p.opts.RetentionBytes
+		if !expired && !oversize {
+			break
+		}
+		oldest.file.Close()
+		if err := os.Remove(oldest.file.Name()); err != nil {
+			return err
+		}
+		total -= oldest.size
+		p.segments = p.segments[1:]
+	}
+	return nil
+}
+
+func (p *logPartition) close() error {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	var errs []error
+	for _, s := range p.segments {
+		errs = append(errs, s.file.Close())
+	}
+	return errors.Join(errs...)
+}
+
+// ConsumerGroup shares the partitions of a topic among its members and
+// stores the offsets they commit on disk, so a restarted consumer resumes
+// where the group left off.
+type ConsumerGroup struct {
+	log       *TopicLog
+	topic     string
+	path      string
+	members   []string
+	committed map[int]uint64
+	positions map[int]uint64
+	mutex     sync.Mutex
+}
+
+// Group returns the consumer group name of topic, loading its committed
+// offsets from disk. The topic is created if it does not exist.
+func (l *TopicLog) Group(topic, name string) (*ConsumerGroup, error) {
+	if !validTopicName(name) {
+		return nil, fmt.Errorf("invalid group name %q", name)
+	}
+	t, err := l.topic(topic, true)
+	if err != nil {
+		return nil, err
+	}
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	if g, ok := t.groups[name]; ok {
+		return g, nil
+	}
+
+	g := &ConsumerGroup{
+		log:       l,
+		topic:     topic,
+		path:      filepath.Join(t.dir, "groups", name+".offsets"),
+		committed: make(map[int]uint64),
+	}
+	data, err := os.ReadFile(g.path)
+	switch {
+	case err == nil:
+		if err := json.Unmarshal(data, &g.committed); err != nil {
+			return nil, fmt.Errorf("reading offsets of group %s: %w", name, err)
+		}
+	case !errors.Is(err, os.ErrNotExist):
+		return nil, err
+	}
+	g.resetPositions()
+	t.groups[name] = g
+	return g, nil
+}
+
+// resetPositions rewinds every partition to its committed offset. It runs on
+// each rebalance, so records consumed but not committed by a previous owner
+// are delivered again. Callers hold g.mutex.
+func (g *ConsumerGroup) resetPositions() {
+	g.positions = make(map[int]uint64, len(g.committed))
+	for p, off := range g.committed {
+		g.positions[p] = off
+	}
+}
+
+// Join adds a member to the group and rebalances partitions across members.
+func (g *ConsumerGroup) Join(memberID string) *Consumer {
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+	i := sort.SearchStrings(g.members, memberID)
+	if i == len(g.members) || g.members[i] != memberID {
+		g.members = append(g.members, "")
+		copy(g.members[i+1:], g.members[i:])
+		g.members[i] = memberID
+		g.resetPositions()
+	}
+	return &Consumer{group: g, id: memberID}
+}
+
+// Committed returns the next offset the group will consume from partition.
+func (g *ConsumerGroup) Committed(partition int) uint64 {
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+	return g.committed[partition]
+}
+
+// Seek moves the group's position on partition, e.g. to replay from an older
+// offset. The new position takes effect for the next Poll and is persisted
+// by the next Commit.
+func (g *ConsumerGroup) Seek(partition int, offset uint64) {
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+	g.positions[partition] = offset
+}
+
+func (g *ConsumerGroup) assignment(memberID string) []int {
+	n := g.log.Partitions(g.topic)
+	i := sort.SearchStrings(g.members, memberID)
+	if i == len(g.members) || g.members[i] != memberID {
+		return nil
+	}
+	var parts []int
+	for p := i; p < n; p += len(g.members) {
+		parts = append(parts, p)
+	}
+	return parts
+}
+
+func (g *ConsumerGroup) save() error {
+	data, err := json.Marshal(g.committed)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(g.path), 0o755); err != nil {
+		return err
+	}
+	tmp := g.path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, g.path)
+}
+
+// Consumer is one member of a ConsumerGroup.
+type Consumer struct {
+	group *ConsumerGroup
+	id    string
+	next  int // index into the assignment Poll starts at, guarded by group.mutex
+}
+
+// Assignment returns the partitions currently owned by c.
+func (c *Consumer) Assignment() []int {
+	c.group.mutex.Lock()
+	defer c.group.mutex.Unlock()
+	return c.group.assignment(c.id)
+}
+
+// Poll returns up to max records from c's partitions and advances the group
+// position past them. If retention removed records the group had not yet
+// consumed, consumption continues from the oldest retained record. Each Poll
+// starts at the partition after the one the previous Poll started at, so a
+// backlog on one partition cannot starve the others.
+func (c *Consumer) Poll(max int) ([]Record, error) {
+	g := c.group
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+
+	assigned := g.assignment(c.id)
+	if len(assigned) == 0 {
+		return nil, nil
+	}
+	start := c.next % len(assigned)
+	c.next = start + 1
+
+	var records []Record
+	for i := range assigned {
+		p := assigned[(start+i)%len(assigned)]
+		if len(records) >= max {
+			break
+		}
+		part, err := g.log.partition(g.topic, p)
+		if err != nil {
+			return records, err
+		}
+		pos := g.positions[p]
+		if first, _ := part.bounds(); pos < first {
+			pos = first
+		}
+		batch, err := part.read(pos, max-len(records))
+		if err != nil {
+			return records, err
+		}
+		if len(batch) > 0 {
+			g.positions[p] = batch[len(batch)-1].Offset + 1
+		}
+		records = append(records, batch...)
+	}
+	return records, nil
+}
+
+// Commit stores the group position of c's partitions on disk.
+func (c *Consumer) Commit() error {
+	g := c.group
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+	for _, p := range g.assignment(c.id) {
+		if pos, ok := g.positions[p]; ok {
+			g.committed[p] = pos
+		}
+	}
+	return g.save()
+}
+
+// Leave removes c from the group and rebalances its partitions.
+func (c *Consumer) Leave() {
+	g := c.group
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+	i := sort.SearchStrings(g.members, c.id)
+	if i < len(g.members) && g.members[i] == c.id {
+		g.members = append(g.members[:i], g.members[i+1:]...)
+		g.resetPositions()
+	}
+}
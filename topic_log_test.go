@@ -0,0 +1,239 @@
+package main
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+	"hash/fnv"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func appendN(t *testing.T, l *TopicLog, topic string, n int) {
+	t.Helper()
+	for i := 0; i < n; i++ {
+		if _, _, err := l.Append(topic, nil, []byte(fmt.Sprintf("msg-%d", i))); err != nil {
+			t.Fatalf("Append() error = %v", err)
+		}
+	}
+}
+
+func TestTopicLogReopen(t *testing.T) {
+	dir := t.TempDir()
+	l, err := OpenTopicLog(dir, TopicLogOptions{SegmentBytes: 64})
+	if err != nil {
+		t.Fatal(err)
+	}
+	appendN(t, l, "orders", 10)
+	l.Close()
+
+	l, err = OpenTopicLog(dir, TopicLogOptions{SegmentBytes: 64})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	segments, _ := filepath.Glob(filepath.Join(dir, "orders", "0", "*.log"))
+	if len(segments) < 2 {
+		t.Errorf("got %d segments, want the log to have rolled", len(segments))
+	}
+
+	recs, err := l.Read("orders", 0, 3, 4)
+	if err != nil {
+		t.Fatalf("Read() error = %v", err)
+	}
+	if len(recs) != 4 {
+		t.Fatalf("Read() returned %d records, want 4", len(recs))
+	}
+	for i, r := range recs {
+		if want := fmt.Sprintf("msg-%d", i+3); r.Offset != uint64(i+3) || string(r.Payload) != want {
+			t.Errorf("record %d = (%d, %q), want (%d, %q)", i, r.Offset, r.Payload, i+3, want)
+		}
+	}
+
+	if _, offset, _ := l.Append("orders", nil, []byte("after reopen")); offset != 10 {
+		t.Errorf("offset after reopen = %d, want 10", offset)
+	}
+}
+
+func TestTopicLogTruncatesTornWrite(t *testing.T) {
+	dir := t.TempDir()
+	l, _ := OpenTopicLog(dir, TopicLogOptions{})
+	appendN(t, l, "orders", 3)
+	l.Close()
+
+	name := filepath.Join(dir, "orders", "0", segmentName(0))
+	info, _ := os.Stat(name)
+	if err := os.Truncate(name, info.Size()-3); err != nil {
+		t.Fatal(err)
+	}
+
+	l, err := OpenTopicLog(dir, TopicLogOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	if _, next, _ := l.Offsets("orders", 0); next != 2 {
+		t.Errorf("next offset after torn write = %d, want 2", next)
+	}
+}
+
+func TestTopicLogTruncatesBogusLength(t *testing.T) {
+	dir := t.TempDir()
+	l, _ := OpenTopicLog(dir, TopicLogOptions{SyncWrites: true})
+	appendN(t, l, "orders", 2)
+	l.Close()
+
+	// A header claiming a huge record must not be trusted for allocation.
+	name := filepath.Join(dir, "orders", "0", segmentName(0))
+	f, err := os.OpenFile(name, os.O_WRONLY|os.O_APPEND, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	header := make([]byte, recordHeaderSize)
+	binary.BigEndian.PutUint32(header[4:8], 0xF0000000)
+	f.Write(header)
+	f.Close()
+
+	l, err = OpenTopicLog(dir, TopicLogOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	if _, next, _ := l.Offsets("orders", 0); next != 2 {
+		t.Errorf("next offset after bogus header = %d, want 2", next)
+	}
+	if _, _, err := l.Append("orders", nil, []byte("after")); err != nil {
+		t.Fatal(err)
+	}
+	if records, _ := l.Read("orders", 0, 2, 1); len(records) != 1 || string(records[0].Payload) != "after" {
+		t.Errorf("Read() after truncation = %v, want the record appended after it", records)
+	}
+}
+
+func TestTopicLogRetention(t *testing.T) {
+	clock := newFakeClock()
+	l, _ := OpenTopicLog(t.TempDir(), TopicLogOptions{SegmentBytes: 64, RetentionAge: time.Hour, Clock: clock})
+	defer l.Close()
+
+	appendN(t, l, "orders", 4)
+	clock.Advance(2 * time.Hour)
+	appendN(t, l, "orders", 4)
+	if err := l.ApplyRetention(); err != nil {
+		t.Fatal(err)
+	}
+
+	first, next, _ := l.Offsets("orders", 0)
+	if first == 0 || next != 8 {
+		t.Errorf("Offsets() = (%d, %d), want old segments removed and next = 8", first, next)
+	}
+	if _, err := l.Read("orders", 0, 0, 1); err != ErrOffsetOutOfRange {
+		t.Errorf("Read() of a deleted offset error = %v, want %v", err, ErrOffsetOutOfRange)
+	}
+}
+
+func TestConsumerGroup(t *testing.T) {
+	dir := t.TempDir()
+	l, _ := OpenTopicLog(dir, TopicLogOptions{Partitions: 4})
+	appendN(t, l, "orders", 20)
+
+	g, err := l.Group("orders", "billing")
+	if err != nil {
+		t.Fatal(err)
+	}
+	a := g.Join("a")
+	b := g.Join("b")
+	if got, other := a.Assignment(), b.Assignment(); len(got) != 2 || len(other) != 2 {
+		t.Fatalf("assignments = %v and %v, want two partitions each", got, other)
+	}
+
+	recsA, _ := a.Poll(100)
+	recsB, _ := b.Poll(100)
+	if len(recsA)+len(recsB) != 20 {
+		t.Errorf("members consumed %d records, want 20", len(recsA)+len(recsB))
+	}
+	if err := a.Commit(); err != nil {
+		t.Fatal(err)
+	}
+	l.Close()
+
+	l, _ = OpenTopicLog(dir, TopicLogOptions{})
+	defer l.Close()
+	g, _ = l.Group("orders", "billing")
+	c := g.Join("c")
+	recs, _ := c.Poll(100)
+	if len(recs) != len(recsB) {
+		t.Errorf("after restart consumed %d records, want the %d b never committed", len(recs), len(recsB))
+	}
+
+	g.Seek(0, 0)
+	recs, _ = c.Poll(100)
+	if len(recs) == 0 || recs[0].Offset != 0 {
+		t.Errorf("Poll() after Seek(0, 0) = %v, want replay from offset 0", recs)
+	}
+}
+
+// keyFor returns a record key that Append places on partition p of n.
+func keyFor(p, n int) []byte {
+	for i := 0; ; i++ {
+		key := []byte(fmt.Sprintf("key-%d", i))
+		h := fnv.New32()
+		h.Write(key)
+		if int(h.Sum32()%uint32(n)) == p {
+			return key
+		}
+	}
+}
+
+func TestConsumerPollRotatesPartitions(t *testing.T) {
+	l, _ := OpenTopicLog(t.TempDir(), TopicLogOptions{Partitions: 2})
+	defer l.Close()
+	hot, cold := keyFor(0, 2), keyFor(1, 2)
+	for i := 0; i < 10; i++ {
+		l.Append("events", hot, []byte("hot"))
+	}
+	l.Append("events", cold, []byte("cold"))
+
+	g, err := l.Group("events", "workers")
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := g.Join("only")
+	if got := c.Assignment(); len(got) != 2 {
+		t.Fatalf("Assignment() = %v, want both partitions", got)
+	}
+
+	// Partition 0 alone could fill every Poll; the backlog must not keep
+	// partition 1 waiting.
+	var perPartition [2]int
+	for i := 0; i < 2; i++ {
+		recs, err := c.Poll(3)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, r := range recs {
+			perPartition[r.Partition]++
+		}
+	}
+	if perPartition[1] != 1 {
+		t.Errorf("two polls read %v records per partition, want the cold record from partition 1", perPartition)
+	}
+	if perPartition[0]+perPartition[1] != 6 {
+		t.Errorf("two polls of 3 returned %d records, want 6", perPartition[0]+perPartition[1])
+	}
+}
+
+func TestBrokerPersistTo(t *testing.T) {
+	l, _ := OpenTopicLog(t.TempDir(), TopicLogOptions{})
+	defer l.Close()
+	b := NewBroker()
+	b.PersistTo(l)
+
+	b.Publish(context.Background(), "orders.created", []byte("nobody listening"))
+	recs, err := l.Read("orders.created", 0, 0, 10)
+	if err != nil || len(recs) != 1 || string(recs[0].Payload) != "nobody listening" {
+		t.Errorf("Read() = %v, %v, want the published message", recs, err)
+	}
+}
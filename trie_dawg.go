@@ -0,0 +1,321 @@
+package main
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"iter"
+	"math"
+	"os"
+	"slices"
+	"unicode/utf8"
+)
+
+var (
+	ErrCorruptDictionary  = errors.New("corrupt dictionary file")
+	ErrDictionaryTooLarge = errors.New("dictionary exceeds 4 GiB")
+)
+
+// A compiled dictionary is a minimized DAWG (directed acyclic word graph):
+// the trie with identical subtrees merged, so words share suffixes as well
+// as prefixes. All integers are little-endian uint32s:
+//
+//	header: magic, version, node count, root offset
+//	node:   word count, isEnd, edge count, edges sorted by rune
+//	edge:   rune, target node offset
+//
+// Nodes are written children first, so every edge points backwards and the
+// root is the last node. Fixed-width edges let lookups binary search the
+// mapped file directly instead of decoding it.
+const (
+	dawgMagic      = 0x47574144 // "DAWG"
+	dawgVersion    = 1
+	dawgHeaderSize = 16
+	dawgNodeHeader = 12
+	dawgEdgeSize   = 8
+)
+
+// dawgMaxSize is the largest file WriteDictionary produces, since offsets
+// are uint32s. Tests lower it.
+var dawgMaxSize int64 = math.MaxUint32
+
+// WriteDictionary compiles the trie into a minimized DAWG and writes it to
+// w. Word scores are not kept: merging subtrees only works for plain word
+// sets. A dictionary larger than 4 GiB cannot be addressed and fails with
+// ErrDictionaryTooLarge before anything is written.
+func (t *Trie) WriteDictionary(w io.Writer) (int64, error) {
+	c := dawgCompiler{
+		buf: make([]byte, dawgHeaderSize),
+		ids: make(map[string]uint32),
+	}
+	root, err := c.compile(t.root)
+	if err != nil {
+		return 0, err
+	}
+
+	binary.LittleEndian.PutUint32(c.buf[0:], dawgMagic)
+	binary.LittleEndian.PutUint32(c.buf[4:], dawgVersion)
+	binary.LittleEndian.PutUint32(c.buf[8:], uint32(len(c.ids)))
+	binary.LittleEndian.PutUint32(c.buf[12:], root)
+	n, err := w.Write(c.buf)
+	return int64(n), err
+}
+
+type dawgCompiler struct {
+	buf []byte
+	ids map[string]uint32 // node signature to offset of its encoding
+}
+
+// compile encodes n and its subtree, reusing the encoding of an identical
+// subtree if one was already written, and returns its offset.
+func (c *dawgCompiler) compile(n *Node) (uint32, error) {
+	if uint64(n.count) > math.MaxUint32 {
+		return 0, ErrDictionaryTooLarge
+	}
+	node := make([]byte, dawgNodeHeader, dawgNodeHeader+len(n.children)*dawgEdgeSize)
+	binary.LittleEndian.PutUint32(node[0:], uint32(n.count))
+	if n.isEnd {
+		binary.LittleEndian.PutUint32(node[4:], 1)
+	}
+	binary.LittleEndian.PutUint32(node[8:], uint32(len(n.children)))
+	for _, child := range n.children {
+		off, err := c.compile(child)
+		if err != nil {
+			return 0, err
+		}
+		node = binary.LittleEndian.AppendUint32(node, uint32(child.char))
+		node = binary.LittleEndian.AppendUint32(node, off)
+	}
+
+	// Children are already merged, so the encoding itself identifies the
+	// subtree.
+	if off, ok := c.ids[string(node)]; ok {
+		return off, nil
+	}
+	if int64(len(c.buf)+len(node)) > dawgMaxSize {
+		return 0, ErrDictionaryTooLarge
+	}
+	off := uint32(len(c.buf))
+	c.buf = append(c.buf, node...)
+	c.ids[string(node)] = off
+	return off, nil
+}
+
+// Dictionary is a read-only word set served straight from a compiled DAWG,
+// typically a memory-mapped file. It is safe for concurrent use.
+type Dictionary struct {
+	data  []byte
+	root  uint32
+	nodes int
+	keyOptions
+	unmap func() error
+}
+
+// OpenDictionary memory-maps a file written by WriteDictionary. The options
+// must match those of the trie it was compiled from, since queries are
+// folded and normalized the same way as the stored words.
+func OpenDictionary(path string, opts ...TrieOption) (*Dictionary, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+	if info.Size() < dawgHeaderSize {
+		return nil, ErrCorruptDictionary
+	}
+	data, err := mmapFile(f, int(info.Size()), false)
+	if err != nil {
+		return nil, err
+	}
+
+	d, err := ParseDictionary(data, opts...)
+	if err != nil {
+		munmap(data)
+		return nil, err
+	}
+	d.unmap = func() error { return munmap(data) }
+	return d, nil
+}
+
+// ParseDictionary validates a compiled DAWG held in data and serves queries
+// from it without copying.
+func ParseDictionary(data []byte, opts ...TrieOption) (*Dictionary, error) {
+	if len(data) < dawgHeaderSize ||
+		binary.LittleEndian.Uint32(data[0:]) != dawgMagic ||
+		binary.LittleEndian.Uint32(data[4:]) != dawgVersion {
+		return nil, ErrCorruptDictionary
+	}
+	d := &Dictionary{
+		data:       data,
+		root:       binary.LittleEndian.Uint32(data[12:]),
+		nodes:      int(binary.LittleEndian.Uint32(data[8:])),
+		keyOptions: newKeyOptions(opts),
+	}
+	if err := d.validate(); err != nil {
+		return nil, err
+	}
+	return d, nil
+}
+
+// validate checks once that every node and edge is in bounds and that edges
+// point backwards to a node start, so lookups need no checks of their own
+// and cannot loop.
+func (d *Dictionary) validate() error {
+	var starts []uint32
+	off := uint32(dawgHeaderSize)
+	for int(off) < len(d.data) {
+		if len(d.data)-int(off) < dawgNodeHeader {
+			return fmt.Errorf("%w: truncated node at %d", ErrCorruptDictionary, off)
+		}
+		edges := binary.LittleEndian.Uint32(d.data[off+8:])
+		if uint64(len(d.data)-int(off)-dawgNodeHeader) < uint64(edges)*dawgEdgeSize {
+			return fmt.Errorf("%w: truncated edges at %d", ErrCorruptDictionary, off)
+		}
+		var prev rune = -1
+		for i := uint32(0); i < edges; i++ {
+			e := off + dawgNodeHeader + i*dawgEdgeSize
+			r := rune(binary.LittleEndian.Uint32(d.data[e:]))
+			target := binary.LittleEndian.Uint32(d.data[e+4:])
+			if r <= prev || !utf8.ValidRune(r) {
+				return fmt.Errorf("%w: bad edge label at %d", ErrCorruptDictionary, e)
+			}
+			if _, ok := slices.BinarySearch(starts, target); !ok {
+				return fmt.Errorf("%w: bad edge target at %d", ErrCorruptDictionary, e)
+			}
+			prev = r
+		}
+		starts = append(starts, off)
+		off += dawgNodeHeader + edges*dawgEdgeSize
+	}
+	if len(starts) != d.nodes || len(starts) == 0 || starts[len(starts)-1] != d.root {
+		return fmt.Errorf("%w: bad node count or root", ErrCorruptDictionary)
+	}
+	return nil
+}
+
+// Nodes returns the number of distinct nodes after minimization.
+func (d *Dictionary) Nodes() int {
+	return d.nodes
+}
+
+// Len returns the number of words.
+func (d *Dictionary) Len() int {
+	return d.count(d.root)
+}
+
+func (d *Dictionary) count(node uint32) int {
+	return int(binary.LittleEndian.Uint32(d.data[node:]))
+}
+
+func (d *Dictionary) isEnd(node uint32) bool {
+	return binary.LittleEndian.Uint32(d.data[node+4:]) != 0
+}
+
+func (d *Dictionary) edges(node uint32) []byte {
+	n := binary.LittleEndian.Uint32(d.data[node+8:])
+	start := node + dawgNodeHeader
+	return d.data[start : start+n*dawgEdgeSize]
+}
+
+// child binary searches node's edges for r.
+func (d *Dictionary) child(node uint32, r rune) (uint32, bool) {
+	edges := d.edges(node)
+	lo, hi := 0, len(edges)/dawgEdgeSize
+	for lo < hi {
+		mid := int(uint(lo+hi) >> 1)
+		e := edges[mid*dawgEdgeSize:]
+		switch c := rune(binary.LittleEndian.Uint32(e)); {
+		case c == r:
+			return binary.LittleEndian.Uint32(e[4:]), true
+		case c < r:
+			lo = mid + 1
+		default:
+			hi = mid
+		}
+	}
+	return 0, false
+}
+
+func (d *Dictionary) find(key string) (uint32, bool) {
+	node := d.root
+	for _, r := range key {
+		var ok bool
+		if node, ok = d.child(node, r); !ok {
+			return 0, false
+		}
+	}
+	return node, true
+}
+
+func (d *Dictionary) Search(w string) bool {
+	node, ok := d.find(d.key(w))
+	return ok && d.isEnd(node)
+}
+
+// StartsWith reports whether any word begins with prefix.
+func (d *Dictionary) StartsWith(prefix string) bool {
+	return d.CountWithPrefix(prefix) > 0
+}
+
+// CountWithPrefix returns the number of words beginning with prefix.
+func (d *Dictionary) CountWithPrefix(prefix string) int {
+	node, ok := d.find(d.key(prefix))
+	if !ok {
+		return 0
+	}
+	return d.count(node)
+}
+
+// KeysWithPrefix returns up to limit words beginning with prefix in
+// lexicographic order. A limit of zero or less returns them all.
+func (d *Dictionary) KeysWithPrefix(prefix string, limit int) []string {
+	var keys []string
+	for w := range d.Completions(prefix) {
+		if limit > 0 && len(keys) == limit {
+			break
+		}
+		keys = append(keys, w)
+	}
+	return keys
+}
+
+// Completions streams the words beginning with prefix in lexicographic
+// order.
+func (d *Dictionary) Completions(prefix string) iter.Seq[string] {
+	return func(yield func(string) bool) {
+		key := d.key(prefix)
+		if node, ok := d.find(key); ok {
+			d.walk(node, []byte(key), yield)
+		}
+	}
+}
+
+func (d *Dictionary) walk(node uint32, buf []byte, yield func(string) bool) bool {
+	if d.isEnd(node) && !yield(string(buf)) {
+		return false
+	}
+	edges := d.edges(node)
+	for i := 0; i < len(edges); i += dawgEdgeSize {
+		r := rune(binary.LittleEndian.Uint32(edges[i:]))
+		target := binary.LittleEndian.Uint32(edges[i+4:])
+		if !d.walk(target, utf8.AppendRune(buf, r), yield) {
+			return false
+		}
+	}
+	return true
+}
+
+// Close unmaps the file. The Dictionary must not be used afterwards.
+func (d *Dictionary) Close() error {
+	if d.unmap == nil {
+		return nil
+	}
+	err := d.unmap()
+	d.unmap = nil
+	return err
+}
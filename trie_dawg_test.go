@@ -0,0 +1,145 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+func compileDictionary(t *testing.T, trie *Trie, opts ...TrieOption) *Dictionary {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "words.dawg")
+	f, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := trie.WriteDictionary(f); err != nil {
+		t.Fatal(err)
+	}
+	if err := f.Close(); err != nil {
+		t.Fatal(err)
+	}
+	d, err := OpenDictionary(path, opts...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { d.Close() })
+	return d
+}
+
+func TestDictionarySharesSuffixes(t *testing.T) {
+	trie := InitTrie(WithCaseFolding())
+	for _, w := range []string{"tap", "taps", "top", "tops", "Zürich"} {
+		trie.Insert(w)
+	}
+	d := compileDictionary(t, trie, WithCaseFolding())
+
+	// The root, "t", the shared "ta"/"to" node and "tap"/"top", then five
+	// nodes for "zürich" whose final leaf merges with that of "taps"/"tops".
+	if d.Nodes() != 10 {
+		t.Errorf("Nodes() = %d, want 10", d.Nodes())
+	}
+	if d.Len() != 5 {
+		t.Errorf("Len() = %d, want 5", d.Len())
+	}
+	for _, w := range []string{"tap", "TOPS", "zürich"} {
+		if !d.Search(w) {
+			t.Errorf("Search(%q) = false", w)
+		}
+	}
+	for _, w := range []string{"ta", "tapss", "tip", ""} {
+		if d.Search(w) {
+			t.Errorf("Search(%q) = true", w)
+		}
+	}
+	if got := d.KeysWithPrefix("t", 0); !slices.Equal(got, []string{"tap", "taps", "top", "tops"}) {
+		t.Errorf("KeysWithPrefix(\"t\") = %v", got)
+	}
+	if n := d.CountWithPrefix("to"); n != 2 || !d.StartsWith("zü") || d.StartsWith("x") {
+		t.Errorf("CountWithPrefix(\"to\") = %d, StartsWith(\"zü\") = %v", n, d.StartsWith("zü"))
+	}
+}
+
+func TestDictionaryMatchesTrie(t *testing.T) {
+	trie := InitTrie()
+	for _, w := range benchmarkDictionary(5000) {
+		trie.Insert(w)
+	}
+	d := compileDictionary(t, trie)
+
+	rng := rand.New(rand.NewSource(3))
+	queries := benchmarkDictionary(200)
+	for _, q := range queries {
+		q = q[:rng.Intn(len(q)+1)]
+		if a, b := trie.Search(q), d.Search(q); a != b {
+			t.Fatalf("Search(%q): trie %v, dictionary %v", q, a, b)
+		}
+		if a, b := trie.CountWithPrefix(q), d.CountWithPrefix(q); a != b {
+			t.Fatalf("CountWithPrefix(%q): trie %d, dictionary %d", q, a, b)
+		}
+		if a, b := trie.KeysWithPrefix(q, 20), d.KeysWithPrefix(q, 20); !slices.Equal(a, b) {
+			t.Fatalf("KeysWithPrefix(%q): trie %v, dictionary %v", q, a, b)
+		}
+	}
+}
+
+func TestWriteDictionaryTooLarge(t *testing.T) {
+	trie := InitTrie()
+	for _, w := range []string{"alpha", "beta", "gamma"} {
+		trie.Insert(w)
+	}
+	var full bytes.Buffer
+	if _, err := trie.WriteDictionary(&full); err != nil {
+		t.Fatal(err)
+	}
+
+	defer func(size int64) { dawgMaxSize = size }(dawgMaxSize)
+	dawgMaxSize = int64(full.Len() - 1)
+	var buf bytes.Buffer
+	if _, err := trie.WriteDictionary(&buf); !errors.Is(err, ErrDictionaryTooLarge) {
+		t.Errorf("WriteDictionary() past the size limit error = %v, want %v", err, ErrDictionaryTooLarge)
+	}
+	if buf.Len() != 0 {
+		t.Errorf("WriteDictionary() wrote %d bytes before failing", buf.Len())
+	}
+}
+
+func TestParseDictionaryRejectsCorruption(t *testing.T) {
+	trie := InitTrie()
+	trie.Insert("argon")
+	trie.Insert("aragon")
+	var buf bytes.Buffer
+	if _, err := trie.WriteDictionary(&buf); err != nil {
+		t.Fatal(err)
+	}
+	good := buf.Bytes()
+	if _, err := ParseDictionary(good); err != nil {
+		t.Fatalf("ParseDictionary() of a valid file error = %v", err)
+	}
+
+	tests := []struct {
+		name   string
+		mangle func(b []byte) []byte
+	}{
+		{"empty", func(b []byte) []byte { return nil }},
+		{"bad magic", func(b []byte) []byte { b[0] ^= 0xff; return b }},
+		{"truncated", func(b []byte) []byte { return b[:len(b)-3] }},
+		{"edge pointing forwards", func(b []byte) []byte {
+			// The root is last; make its first edge point at itself.
+			root := len(b) - dawgNodeHeader - dawgEdgeSize
+			copy(b[root+dawgNodeHeader+4:], b[12:16])
+			return b
+		}},
+		{"huge edge count", func(b []byte) []byte { b[dawgHeaderSize+8] = 0xff; return b }},
+	}
+	for _, tt := range tests {
+		b := tt.mangle(bytes.Clone(good))
+		if _, err := ParseDictionary(b); !errors.Is(err, ErrCorruptDictionary) {
+			t.Errorf("%s: ParseDictionary() error = %v, want %v", tt.name, err, ErrCorruptDictionary)
+		}
+	}
+}
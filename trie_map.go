@@ -0,0 +1,177 @@
+package main
+
+import "iter"
+
+type trieMapNode[V any] struct {
+	b        byte
+	children []*trieMapNode[V] // sorted by b
+	hasValue bool
+	value    V
+}
+
+func (n *trieMapNode[V]) edge() byte {
+	return n.b
+}
+
+func (n *trieMapNode[V]) child(b byte) *trieMapNode[V] {
+	return findChild(n.children, b)
+}
+
+func (n *trieMapNode[V]) addChild(b byte) *trieMapNode[V] {
+	var c *trieMapNode[V]
+	n.children, c = insertChild(n.children, b, func() *trieMapNode[V] { return &trieMapNode[V]{b: b} })
+	return c
+}
+
+func (n *trieMapNode[V]) removeChild(b byte) {
+	n.children = deleteChild(n.children, b)
+}
+
+// TrieMap maps string keys to values and answers prefix questions about
+// them, such as which stored key is the longest prefix of a path. Keys are
+// compared byte by byte, so any string, including the empty one, is a valid
+// key and iteration is in lexicographic byte order.
+type TrieMap[V any] struct {
+	root *trieMapNode[V]
+	size int
+}
+
+func NewTrieMap[V any]() *TrieMap[V] {
+	return &TrieMap[V]{root: &trieMapNode[V]{}}
+}
+
+func (m *TrieMap[V]) Len() int {
+	return m.size
+}
+
+// Put stores value under key, replacing any previous value.
+func (m *TrieMap[V]) Put(key string, value V) {
+	n := m.root
+	for i := 0; i < len(key); i++ {
+		n = n.addChild(key[i])
+	}
+	if !n.hasValue {
+		n.hasValue = true
+		m.size++
+	}
+	n.value = value
+}
+
+func (m *TrieMap[V]) Get(key string) (V, bool) {
+	n := m.find(key)
+	if n == nil || !n.hasValue {
+		var zero V
+		return zero, false
+	}
+	return n.value, true
+}
+
+func (m *TrieMap[V]) find(key string) *trieMapNode[V] {
+	n := m.root
+	for i := 0; i < len(key) && n != nil; i++ {
+		n = n.child(key[i])
+	}
+	return n
+}
+
+// Delete removes key and reports whether it was present, pruning nodes that
+// no longer lead to a value.
+func (m *TrieMap[V]) Delete(key string) bool {
+	path := make([]*trieMapNode[V], 0, len(key)+1)
+	n := m.root
+	path = append(path, n)
+	for i := 0; i < len(key); i++ {
+		if n = n.child(key[i]); n == nil {
+			return false
+		}
+		path = append(path, n)
+	}
+	if !n.hasValue {
+		return false
+	}
+
+	var zero V
+	n.hasValue, n.value = false, zero
+	m.size--
+	for i := len(path) - 1; i > 0; i-- {
+		if n := path[i]; n.hasValue || len(n.children) > 0 {
+			break
+		}
+		path[i-1].removeChild(key[i-1])
+	}
+	return true
+}
+
+// LongestPrefixOf returns the longest stored key that is a byte prefix of s.
+// The match need not end on a path segment: "/api" is a prefix of "/apiv2".
+// Use LongestSegmentPrefixOf to find the most specific route for a path.
+func (m *TrieMap[V]) LongestPrefixOf(s string) (key string, value V, ok bool) {
+	m.WalkPath(s, func(k string, v V) bool {
+		key, value, ok = k, v, true
+		return true
+	})
+	return key, value, ok
+}
+
+// LongestSegmentPrefixOf is like LongestPrefixOf but only matches whole
+// segments of s separated by sep, e.g. the most specific route for a request
+// path with sep '/'. A key matches if it is s, ends in sep, or is followed
+// by sep in s, so "/api" matches "/api" and "/api/users" but not "/apiv2".
+func (m *TrieMap[V]) LongestSegmentPrefixOf(s string, sep byte) (key string, value V, ok bool) {
+	m.WalkPath(s, func(k string, v V) bool {
+		if len(k) == len(s) || len(k) > 0 && k[len(k)-1] == sep || s[len(k)] == sep {
+			key, value, ok = k, v, true
+		}
+		return true
+	})
+	return key, value, ok
+}
+
+// WalkPath calls fn for every stored key that is a prefix of s, shortest
+// first, until fn returns false.
+func (m *TrieMap[V]) WalkPath(s string, fn func(key string, value V) bool) {
+	n := m.root
+	for i := 0; ; i++ {
+		if n.hasValue && !fn(s[:i], n.value) {
+			return
+		}
+		if i == len(s) {
+			return
+		}
+		if n = n.child(s[i]); n == nil {
+			return
+		}
+	}
+}
+
+// Walk calls fn for every key and value in lexicographic key order until fn
+// returns false.
+func (m *TrieMap[V]) Walk(fn func(key string, value V) bool) {
+	m.WalkPrefix("", fn)
+}
+
+// WalkPrefix is like Walk but only visits keys beginning with prefix.
+func (m *TrieMap[V]) WalkPrefix(prefix string, fn func(key string, value V) bool) {
+	if n := m.find(prefix); n != nil {
+		walkTrieMap(n, []byte(prefix), fn)
+	}
+}
+
+func walkTrieMap[V any](n *trieMapNode[V], buf []byte, fn func(string, V) bool) bool {
+	if n.hasValue && !fn(string(buf), n.value) {
+		return false
+	}
+	for _, c := range n.children {
+		if !walkTrieMap(c, append(buf, c.b), fn) {
+			return false
+		}
+	}
+	return true
+}
+
+// All returns an iterator over every key and value in lexicographic order.
+func (m *TrieMap[V]) All() iter.Seq2[string, V] {
+	return func(yield func(string, V) bool) {
+		m.Walk(yield)
+	}
+}
@@ -0,0 +1,149 @@
+package main
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestTrieMapPutGetDelete(t *testing.T) {
+	m := NewTrieMap[int]()
+	m.Put("apple", 1)
+	m.Put("app", 2)
+	m.Put("", 3)
+	m.Put("apple", 4)
+
+	if m.Len() != 3 {
+		t.Errorf("Len() = %d, want 3", m.Len())
+	}
+	tests := []struct {
+		key    string
+		want   int
+		wantOK bool
+	}{
+		{key: "apple", want: 4, wantOK: true},
+		{key: "app", want: 2, wantOK: true},
+		{key: "", want: 3, wantOK: true},
+		{key: "ap"},
+		{key: "apples"},
+	}
+	for _, tt := range tests {
+		if got, ok := m.Get(tt.key); got != tt.want || ok != tt.wantOK {
+			t.Errorf("Get(%q) = (%d, %v), want (%d, %v)", tt.key, got, ok, tt.want, tt.wantOK)
+		}
+	}
+
+	if m.Delete("ap") {
+		t.Error("Delete(\"ap\") = true for a key that was never stored")
+	}
+	if !m.Delete("apple") || m.Delete("apple") {
+		t.Error("Delete(\"apple\") should succeed exactly once")
+	}
+	if _, ok := m.Get("app"); !ok {
+		t.Error("Get(\"app\") failed after deleting \"apple\"")
+	}
+	if n := m.find("app"); len(n.children) != 0 {
+		t.Errorf("\"app\" still has %d children after deleting \"apple\"", len(n.children))
+	}
+	m.Delete("app")
+	m.Delete("")
+	if m.Len() != 0 || len(m.root.children) != 0 {
+		t.Errorf("Len() = %d with %d root children after deleting everything", m.Len(), len(m.root.children))
+	}
+}
+
+func TestTrieMapLongestPrefixOf(t *testing.T) {
+	routes := NewTrieMap[string]()
+	routes.Put("/", "root")
+	routes.Put("/api/", "api")
+	routes.Put("/api/v2/", "api-v2")
+	routes.Put("/static/", "static")
+
+	tests := []struct {
+		path    string
+		wantKey string
+		want    string
+	}{
+		{path: "/api/v2/users", wantKey: "/api/v2/", want: "api-v2"},
+		{path: "/api/v1/users", wantKey: "/api/", want: "api"},
+		{path: "/api/", wantKey: "/api/", want: "api"},
+		{path: "/about", wantKey: "/", want: "root"},
+		{path: "/static/../api/", wantKey: "/static/", want: "static"},
+	}
+	for _, tt := range tests {
+		key, v, ok := routes.LongestPrefixOf(tt.path)
+		if !ok || key != tt.wantKey || v != tt.want {
+			t.Errorf("LongestPrefixOf(%q) = (%q, %q, %v), want (%q, %q, true)", tt.path, key, v, ok, tt.wantKey, tt.want)
+		}
+	}
+	if _, _, ok := routes.LongestPrefixOf("api"); ok {
+		t.Error("LongestPrefixOf(\"api\") matched, want no match")
+	}
+
+	var chain []string
+	routes.WalkPath("/api/v2/x", func(key, _ string) bool {
+		chain = append(chain, key)
+		return true
+	})
+	if !slices.Equal(chain, []string{"/", "/api/", "/api/v2/"}) {
+		t.Errorf("WalkPath() visited %v, want [/ /api/ /api/v2/]", chain)
+	}
+}
+
+func TestTrieMapLongestSegmentPrefixOf(t *testing.T) {
+	routes := NewTrieMap[string]()
+	routes.Put("/", "root")
+	routes.Put("/api", "api")
+	routes.Put("/api/v2/", "api-v2")
+
+	tests := []struct {
+		path    string
+		wantKey string
+	}{
+		{path: "/api", wantKey: "/api"},
+		{path: "/api/users", wantKey: "/api"},
+		{path: "/apiv2/users", wantKey: "/"},
+		{path: "/api/v2/users", wantKey: "/api/v2/"},
+		{path: "/api/v2x", wantKey: "/api"},
+	}
+	for _, tt := range tests {
+		if key, _, ok := routes.LongestSegmentPrefixOf(tt.path, '/'); !ok || key != tt.wantKey {
+			t.Errorf("LongestSegmentPrefixOf(%q) = (%q, %v), want (%q, true)", tt.path, key, ok, tt.wantKey)
+		}
+	}
+	if key, _, _ := routes.LongestPrefixOf("/apiv2/users"); key != "/api" {
+		t.Errorf("LongestPrefixOf(\"/apiv2/users\") = %q, want the byte prefix \"/api\"", key)
+	}
+}
+
+func TestTrieMapWalk(t *testing.T) {
+	m := NewTrieMap[int]()
+	for i, k := range []string{"b", "ab", "a", "abc", "ba"} {
+		m.Put(k, i)
+	}
+
+	var keys []string
+	for k := range m.All() {
+		keys = append(keys, k)
+	}
+	if !slices.Equal(keys, []string{"a", "ab", "abc", "b", "ba"}) {
+		t.Errorf("All() keys = %v, want [a ab abc b ba]", keys)
+	}
+
+	keys = keys[:0]
+	m.WalkPrefix("ab", func(k string, _ int) bool {
+		keys = append(keys, k)
+		return true
+	})
+	if !slices.Equal(keys, []string{"ab", "abc"}) {
+		t.Errorf("WalkPrefix(\"ab\") = %v, want [ab abc]", keys)
+	}
+
+	visited := 0
+	m.Walk(func(string, int) bool {
+		visited++
+		return visited < 2
+	})
+	if visited != 2 {
+		t.Errorf("Walk visited %d keys after fn returned false, want 2", visited)
+	}
+}
@@ -0,0 +1,136 @@
+package main
+
+import (
+	"cmp"
+	"slices"
+	"unicode/utf8"
+)
+
+type FuzzyMatch struct {
+	Word     string
+	Distance int
+}
+
+// FuzzySearch returns the words within maxDistance edits of word, closest
+// first and lexicographic within the same distance. An edit is inserting,
+// deleting or substituting a rune, or swapping two adjacent runes (the
+// optimal string alignment variant of Damerau-Levenshtein distance), so
+// "form" finds "from" at distance 1.
+//
+// It walks the trie computing one row of the edit-distance matrix per node,
+// so words sharing a prefix share that work, and abandons a branch as soon
+// as every entry in its row exceeds maxDistance.
+func (t *Trie) FuzzySearch(word string, maxDistance int) []FuzzyMatch {
+	if maxDistance < 0 {
+		return nil
+	}
+	query := []rune(t.key(word))
+	row := make([]int, len(query)+1)
+	for i := range row {
+		row[i] = i
+	}
+
+	var matches []FuzzyMatch
+	if t.root.isEnd && row[len(query)] <= maxDistance {
+		matches = append(matches, FuzzyMatch{Word: "", Distance: row[len(query)]})
+	}
+	for _, c := range t.root.children {
+		fuzzyWalk(c, query, nil, row, 0, utf8.AppendRune(nil, c.char), maxDistance, &matches)
+	}
+	slices.SortStableFunc(matches, func(a, b FuzzyMatch) int {
+		return cmp.Compare(a.Distance, b.Distance)
+	})
+	return matches
+}
+
+// fuzzyWalk computes the row for n from its parent's row prev, the row
+// before that prevPrev (needed for transpositions) and the parent's rune.
+func fuzzyWalk(n *Node, query []rune, prevPrev, prev []int, parentChar rune, buf []byte, maxDistance int, matches *[]FuzzyMatch) {
+	row := make([]int, len(query)+1)
+	row[0] = prev[0] + 1
+	best := row[0]
+	for j := 1; j <= len(query); j++ {
+		cost := 1
+		if query[j-1] == n.char {
+			cost = 0
+		}
+		row[j] = min(prev[j]+1, row[j-1]+1, prev[j-1]+cost)
+		if j > 1 && prevPrev != nil && query[j-1] == parentChar && query[j-2] == n.char {
+			row[j] = min(row[j], prevPrev[j-2]+1)
+		}
+		best = min(best, row[j])
+	}
+
+	if n.isEnd && row[len(query)] <= maxDistance {
+		*matches = append(*matches, FuzzyMatch{Word: string(buf), Distance: row[len(query)]})
+	}
+	if best > maxDistance {
+		// Rows never improve further down. A transposition below reaches
+		// back to prev, but only where n's rune matched the query, so it
+		// costs at least one more than an entry of row.
+		return
+	}
+	for _, c := range n.children {
+		fuzzyWalk(c, query, prev, row, n.char, utf8.AppendRune(buf, c.char), maxDistance, matches)
+	}
+}
+
+// WildcardSearch returns, in lexicographic order, the words matching
+// pattern, where '?' matches exactly one rune and '*' matches any run of
+// runes, including none. Other runes match themselves after the trie's case
+// folding and normalization.
+func (t *Trie) WildcardSearch(pattern string) []string {
+	p := []rune(t.key(pattern))
+	states := wildcardClosure(p, []int{0})
+
+	var matches []string
+	var walk func(n *Node, states []int, buf []byte)
+	walk = func(n *Node, states []int, buf []byte) {
+		if n.isEnd && slices.Contains(states, len(p)) {
+			matches = append(matches, string(buf))
+		}
+		for _, c := range n.children {
+			if next := wildcardStep(p, states, c.char); len(next) > 0 {
+				walk(c, next, utf8.AppendRune(buf, c.char))
+			}
+		}
+	}
+	walk(t.root, states, nil)
+	return matches
+}
+
+// The pattern is run as an NFA whose states are positions in p. states is
+// always sorted and free of duplicates.
+
+// wildcardStep returns the states reached from states by consuming r.
+func wildcardStep(p []rune, states []int, r rune) []int {
+	var next []int
+	for _, i := range states {
+		switch {
+		case i == len(p):
+		case p[i] == '*':
+			next = append(next, i) // '*' absorbs r and stays put
+		case p[i] == '?' || p[i] == r:
+			next = append(next, i+1)
+		}
+	}
+	return wildcardClosure(p, next)
+}
+
+// wildcardClosure adds the states reachable by letting '*' match nothing.
+func wildcardClosure(p []rune, states []int) []int {
+	var closed []int
+	for _, i := range states {
+		for {
+			if !slices.Contains(closed, i) {
+				closed = append(closed, i)
+			}
+			if i == len(p) || p[i] != '*' {
+				break
+			}
+			i++
+		}
+	}
+	slices.Sort(closed)
+	return closed
+}
@@ -0,0 +1,138 @@
+package main
+
+import (
+	"math/rand"
+	"slices"
+	"testing"
+)
+
+func TestTrieFuzzySearch(t *testing.T) {
+	trie := InitTrie(WithCaseFolding())
+	for _, w := range []string{"from", "form", "forum", "farm", "for", "fro", "front", "zürich"} {
+		trie.Insert(w)
+	}
+
+	tests := []struct {
+		word string
+		max  int
+		want []FuzzyMatch
+	}{
+		{word: "from", max: 0, want: []FuzzyMatch{{"from", 0}}},
+		{
+			word: "from",
+			max:  1,
+			want: []FuzzyMatch{{"from", 0}, {"form", 1}, {"fro", 1}},
+		},
+		{
+			word: "FORM",
+			max:  1,
+			want: []FuzzyMatch{{"form", 0}, {"farm", 1}, {"for", 1}, {"forum", 1}, {"from", 1}},
+		},
+		{word: "zurich", max: 1, want: []FuzzyMatch{{"zürich", 1}}},
+		{word: "xyz", max: 1},
+		{word: "from", max: -1},
+	}
+
+	for _, tt := range tests {
+		got := trie.FuzzySearch(tt.word, tt.max)
+		if !slices.Equal(got, tt.want) {
+			t.Errorf("FuzzySearch(%q, %d) = %v, want %v", tt.word, tt.max, got, tt.want)
+		}
+	}
+}
+
+// osaDistance is a straightforward full-matrix reference implementation.
+func osaDistance(a, b []rune) int {
+	d := make([][]int, len(a)+1)
+	for i := range d {
+		d[i] = make([]int, len(b)+1)
+		d[i][0] = i
+	}
+	for j := range d[0] {
+		d[0][j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			d[i][j] = min(d[i-1][j]+1, d[i][j-1]+1, d[i-1][j-1]+cost)
+			if i > 1 && j > 1 && a[i-1] == b[j-2] && a[i-2] == b[j-1] {
+				d[i][j] = min(d[i][j], d[i-2][j-2]+1)
+			}
+		}
+	}
+	return d[len(a)][len(b)]
+}
+
+func TestTrieFuzzySearchMatchesBruteForce(t *testing.T) {
+	rng := rand.New(rand.NewSource(7))
+	word := func() string {
+		r := make([]rune, rng.Intn(7))
+		for i := range r {
+			r[i] = []rune("abcd")[rng.Intn(4)]
+		}
+		return string(r)
+	}
+
+	trie := InitTrie()
+	var words []string
+	for i := 0; i < 300; i++ {
+		w := word()
+		if !trie.Search(w) {
+			words = append(words, w)
+		}
+		trie.Insert(w)
+	}
+
+	for i := 0; i < 200; i++ {
+		q, max := word(), rng.Intn(3)
+		var want []FuzzyMatch
+		for _, w := range words {
+			if d := osaDistance([]rune(q), []rune(w)); d <= max {
+				want = append(want, FuzzyMatch{w, d})
+			}
+		}
+		slices.SortFunc(want, func(a, b FuzzyMatch) int {
+			if a.Distance != b.Distance {
+				return a.Distance - b.Distance
+			}
+			if a.Word < b.Word {
+				return -1
+			}
+			return 1
+		})
+		if got := trie.FuzzySearch(q, max); !slices.Equal(got, want) {
+			t.Fatalf("FuzzySearch(%q, %d) = %v, want %v", q, max, got, want)
+		}
+	}
+}
+
+func TestTrieWildcardSearch(t *testing.T) {
+	trie := InitTrie()
+	for _, w := range []string{"cat", "cart", "cast", "coat", "cot", "act", "at", "東京都"} {
+		trie.Insert(w)
+	}
+
+	tests := []struct {
+		pattern string
+		want    []string
+	}{
+		{pattern: "c?t", want: []string{"cat", "cot"}},
+		{pattern: "ca?t", want: []string{"cart", "cast"}},
+		{pattern: "c*t", want: []string{"cart", "cast", "cat", "coat", "cot"}},
+		{pattern: "*at", want: []string{"at", "cat", "coat"}},
+		{pattern: "**a**t", want: []string{"act", "at", "cart", "cast", "cat", "coat"}},
+		{pattern: "*", want: []string{"act", "at", "cart", "cast", "cat", "coat", "cot", "東京都"}},
+		{pattern: "東?都", want: []string{"東京都"}},
+		{pattern: "cat", want: []string{"cat"}},
+		{pattern: "?"},
+	}
+
+	for _, tt := range tests {
+		if got := trie.WildcardSearch(tt.pattern); !slices.Equal(got, tt.want) {
+			t.Errorf("WildcardSearch(%q) = %v, want %v", tt.pattern, got, tt.want)
+		}
+	}
+}
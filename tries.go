@@ -1,113 +1,365 @@
-package main 
+package main
 
-import "fmt"
-
-const AlphabetSize = 26
+import (
+	"cmp"
+	"fmt"
+	"iter"
+	"slices"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
 
+// Node is a trie node. Children are kept sorted by rune so lookups are a
+// binary search and walking them visits keys in lexicographic order, while a
+// node only pays for the children it actually has.
 type Node struct {
-	children [26]*Node
-	isEnd bool 
+	char     rune
+	children []*Node
+	isEnd    bool
+	count    int     // words ending at or below this node
+	score    float64 // ranking used by TopK, only meaningful when isEnd
+}
+
+func (n *Node) edge() rune {
+	return n.char
+}
+
+func (n *Node) child(r rune) *Node {
+	return findChild(n.children, r)
+}
+
+// addChild returns the child for r, creating it if needed.
+func (n *Node) addChild(r rune) *Node {
+	var c *Node
+	n.children, c = insertChild(n.children, r, func() *Node { return &Node{char: r} })
+	return c
 }
 
-type Trie struct{
-	root *Node 
+func (n *Node) removeChild(r rune) {
+	n.children = deleteChild(n.children, r)
 }
 
-func InitTrie() *Trie{
-	result := &Trie{root:&Node{}}
-	return result 
+// trieNode is a node kept in its parent's children sorted by the label of
+// the edge leading to it. Node and trieMapNode share their child bookkeeping
+// through it.
+type trieNode[K cmp.Ordered] interface {
+	comparable
+	edge() K
+}
+
+func searchChildren[K cmp.Ordered, N trieNode[K]](children []N, k K) (int, bool) {
+	return slices.BinarySearchFunc(children, k, func(n N, k K) int {
+		return cmp.Compare(n.edge(), k)
+	})
+}
+
+// findChild returns the child on edge k, or the zero N if there is none.
+func findChild[K cmp.Ordered, N trieNode[K]](children []N, k K) N {
+	i, ok := searchChildren(children, k)
+	if !ok {
+		var zero N
+		return zero
+	}
+	return children[i]
+}
+
+// insertChild returns the child on edge k, adding one made by newNode if
+// needed, together with the possibly grown children.
+func insertChild[K cmp.Ordered, N trieNode[K]](children []N, k K, newNode func() N) ([]N, N) {
+	i, ok := searchChildren(children, k)
+	if ok {
+		return children, children[i]
+	}
+	c := newNode()
+	return slices.Insert(children, i, c), c
+}
+
+func deleteChild[K cmp.Ordered, N trieNode[K]](children []N, k K) []N {
+	if i, ok := searchChildren(children, k); ok {
+		return slices.Delete(children, i, i+1)
+	}
+	return children
+}
+
+// Trie stores arbitrary UTF-8 words. Invalid UTF-8 is replaced with U+FFFD
+// before use, so every input is accepted.
+type Trie struct {
+	root *Node
+	keyOptions
+}
+
+// keyOptions controls how words are turned into stored keys. It is shared
+// by the trie variants so they all accept the same TrieOptions.
+type keyOptions struct {
+	foldCase  bool
+	normalize func(string) string
+}
+
+type TrieOption func(*keyOptions)
+
+// WithCaseFolding makes the trie case-insensitive; words are stored in their
+// lower-case form.
+func WithCaseFolding() TrieOption {
+	return func(o *keyOptions) { o.foldCase = true }
 }
 
-func (t *Trie) Insert(w string){
-	wordLength := len(w)
-	currentNode := t.root 
-	for i := 0; i < wordLength; i++{
-		charIndex := w[i] - 'a'
-		if currentNode.children[charIndex] == nil{
-			currentNode.children[charIndex] = &Node{}
+// WithNormalizer applies fn to every word before it is stored or looked up,
+// e.g. norm.NFC.String from golang.org/x/text so that precomposed and
+// decomposed spellings of the same word match. Tries do no Unicode
+// normalization of their own: without this option "é" and "e\u0301" are
+// different words, and normalizing is up to the caller.
+func WithNormalizer(fn func(string) string) TrieOption {
+	return func(o *keyOptions) { o.normalize = fn }
+}
+
+func newKeyOptions(opts []TrieOption) keyOptions {
+	var o keyOptions
+	for _, opt := range opts {
+		opt(&o)
+	}
+	return o
+}
+
+func InitTrie(opts ...TrieOption) *Trie {
+	result := &Trie{root: &Node{}, keyOptions: newKeyOptions(opts)}
+	return result
+}
+
+// key returns the form of w that is actually stored in the trie.
+func (o keyOptions) key(w string) string {
+	w = strings.ToValidUTF8(w, string(unicode.ReplacementChar))
+	if o.normalize != nil {
+		w = strings.ToValidUTF8(o.normalize(w), string(unicode.ReplacementChar))
+	}
+	if o.foldCase {
+		w = strings.Map(foldRune, w)
+	}
+	return w
+}
+
+// foldRune maps every case variant of r to one rune, preferring lower case.
+// Round-tripping through upper case also folds runes such as the Kelvin sign
+// that have no upper-case form of their own.
+func foldRune(r rune) rune {
+	return unicode.ToLower(unicode.ToUpper(r))
+}
+
+// Insert adds w. Inserting a word that is already present keeps its score.
+func (t *Trie) Insert(w string) {
+	t.insert(w, nil)
+}
+
+// InsertWithScore adds w, or updates its score if it is already present.
+// Higher scores rank first in TopK, so a search-frequency count works well.
+func (t *Trie) InsertWithScore(w string, score float64) {
+	t.insert(w, &score)
+}
+
+func (t *Trie) insert(w string, score *float64) {
+	currentNode := t.root
+	path := []*Node{currentNode}
+	for _, r := range t.key(w) {
+		currentNode = currentNode.addChild(r)
+		path = append(path, currentNode)
+	}
+	if !currentNode.isEnd {
+		currentNode.isEnd = true
+		for _, n := range path {
+			n.count++
 		}
-		currentNode = currentNode.children[charIndex]
 	}
-	currentNode.isEnd = true
+	if score != nil {
+		currentNode.score = *score
+	}
+}
+
+func (t *Trie) Search(w string) bool {
+	node := t.find(t.key(w))
+	return node != nil && node.isEnd
 }
 
-func (t *Trie) Search(w string) bool{
-	wordLength := len(w)
-	currentNode := t.root 
-	for i := 0; i < wordLength; i++{
-		charIndex := w[i] - 'a'
-		if currentNode.children[charIndex] == nil{
-			return false 
+// find returns the node reached by following key from the root.
+func (t *Trie) find(key string) *Node {
+	currentNode := t.root
+	for _, r := range key {
+		currentNode = currentNode.child(r)
+		if currentNode == nil {
+			return nil
 		}
-		currentNode = currentNode.children[charIndex]
 	}
-	return currentNode.isEnd
+	return currentNode
 }
 
+// Delete removes word and reports whether it was present. Nodes no longer
+// leading to any word are pruned.
 func (t *Trie) Delete(word string) bool {
-	if len(word) == 0 {
-		return false
-	}
-	
-	return deleteHelper(t.root, word, 0)
+	return deleteHelper(t.root, []rune(t.key(word)))
 }
 
-func deleteHelper(current *Node, word string, index int) bool {
-	if index == len(word) {
+func deleteHelper(current *Node, word []rune) bool {
+	if len(word) == 0 {
 		if !current.isEnd {
 			return false
 		}
-		
+
 		current.isEnd = false
-		
-		return hasNoChildren(current)
+		current.score = 0
+		current.count--
+
+		return true
+	}
+
+	child := current.child(word[0])
+	if child == nil {
+		return false
 	}
-	
-	charIndex := word[index] - 'a'
-	
-	if current.children[charIndex] == nil {
+
+	if !deleteHelper(child, word[1:]) {
 		return false
 	}
-	
-	shouldDeleteChild := deleteHelper(current.children[charIndex], word, index+1)
-	
-	if shouldDeleteChild {
-		current.children[charIndex] = nil
-		
-		return !current.isEnd && hasNoChildren(current)
+
+	current.count--
+
+	if !child.isEnd && hasNoChildren(child) {
+		current.removeChild(word[0])
 	}
-	
-	return false
+
+	return true
 }
 
 func hasNoChildren(node *Node) bool {
-	for i := 0; i < AlphabetSize; i++ {
-		if node.children[i] != nil {
+	return len(node.children) == 0
+}
+
+// StartsWith reports whether any word begins with prefix.
+func (t *Trie) StartsWith(prefix string) bool {
+	return t.CountWithPrefix(prefix) > 0
+}
+
+// CountWithPrefix returns the number of words beginning with prefix, in
+// constant time per prefix rune.
+func (t *Trie) CountWithPrefix(prefix string) int {
+	node := t.find(t.key(prefix))
+	if node == nil {
+		return 0
+	}
+	return node.count
+}
+
+// KeysWithPrefix returns up to limit words beginning with prefix in
+// lexicographic order. A limit of zero or less returns them all. Words are
+// returned in their stored form, i.e. after case folding and normalization.
+func (t *Trie) KeysWithPrefix(prefix string, limit int) []string {
+	var keys []string
+	for w := range t.Completions(prefix) {
+		if limit > 0 && len(keys) == limit {
+			break
+		}
+		keys = append(keys, w)
+	}
+	return keys
+}
+
+// Completions streams the words beginning with prefix in lexicographic
+// order, so a caller can stop early without the rest being collected.
+func (t *Trie) Completions(prefix string) iter.Seq[string] {
+	return func(yield func(string) bool) {
+		key := t.key(prefix)
+		if node := t.find(key); node != nil {
+			walkWords(node, []byte(key), yield)
+		}
+	}
+}
+
+func walkWords(n *Node, buf []byte, yield func(string) bool) bool {
+	if n.isEnd && !yield(string(buf)) {
+		return false
+	}
+	for _, c := range n.children {
+		if !walkWords(c, utf8.AppendRune(buf, c.char), yield) {
 			return false
 		}
 	}
 	return true
 }
 
+type Completion struct {
+	Word  string
+	Score float64
+}
+
+// TopK returns the k highest-scoring words beginning with prefix, best
+// first. Equal scores are ordered lexicographically.
+func (t *Trie) TopK(prefix string, k int) []Completion {
+	if k <= 0 {
+		return nil
+	}
+	key := t.key(prefix)
+	node := t.find(key)
+	if node == nil {
+		return nil
+	}
+
+	best := make([]Completion, 0, k)
+	var walk func(n *Node, buf []byte)
+	walk = func(n *Node, buf []byte) {
+		if n.isEnd {
+			best = pushTopK(best, k, Completion{Word: string(buf), Score: n.score})
+		}
+		for _, c := range n.children {
+			walk(c, utf8.AppendRune(buf, c.char))
+		}
+	}
+	walk(node, []byte(key))
+	return best
+}
+
+// pushTopK adds c to best, which holds at most k completions sorted by
+// descending score, if c ranks high enough. Callers pass words in
+// lexicographic order, so placing c after equal scores keeps ties ordered.
+func pushTopK(best []Completion, k int, c Completion) []Completion {
+	if len(best) == k && c.Score <= best[k-1].Score {
+		return best
+	}
+	i, _ := slices.BinarySearchFunc(best, c.Score, func(b Completion, score float64) int {
+		if b.Score >= score {
+			return -1
+		}
+		return 1
+	})
+	if len(best) == k {
+		best = best[:k-1]
+	}
+	return slices.Insert(best, i, c)
+}
+
 func main() {
-	myTrie := InitTrie()
-	
+	myTrie := InitTrie(WithCaseFolding())
+
 	toAdd := []string{
 		"aragon",
 		"argon",
 		"eragon",
 		"oregon",
 		"oreo",
+		"Zürich",
+		"東京",
 	}
 
 	for _, v := range toAdd {
 		myTrie.Insert(v)
 	}
-	
+
 	fmt.Println("Search 'area':", myTrie.Search("area"))
 	fmt.Println("Search 'argon':", myTrie.Search("argon"))
-	
+	fmt.Println("Search 'ZÜRICH':", myTrie.Search("ZÜRICH"))
+	fmt.Println("Search '東京':", myTrie.Search("東京"))
+
+	fmt.Println("Words starting with 'ar':", myTrie.KeysWithPrefix("ar", 10))
+	fmt.Println("Words starting with 'ore':", myTrie.CountWithPrefix("ore"))
+
 	fmt.Println("Deleting 'argon':", myTrie.Delete("argon"))
 	fmt.Println("Search 'argon' after deletion:", myTrie.Search("argon"))
-	
+
 }
@@ -0,0 +1,218 @@
+package main
+
+import (
+	"slices"
+	"strings"
+	"testing"
+	"unicode/utf8"
+)
+
+func TestTrieUnicode(t *testing.T) {
+	tests := []struct {
+		name   string
+		opts   []TrieOption
+		insert []string
+		search string
+		want   bool
+	}{
+		{name: "ascii", insert: []string{"argon"}, search: "argon", want: true},
+		{name: "prefix is not a word", insert: []string{"argon"}, search: "arg", want: false},
+		{name: "upper case and digits", insert: []string{"HTTP2 Server"}, search: "HTTP2 Server", want: true},
+		{name: "case sensitive by default", insert: []string{"Zürich"}, search: "zürich", want: false},
+		{name: "case folding", opts: []TrieOption{WithCaseFolding()}, insert: []string{"Zürich"}, search: "ZÜRICH", want: true},
+		{name: "kelvin sign folds to k", opts: []TrieOption{WithCaseFolding()}, insert: []string{"kelvin"}, search: "Kelvin", want: true},
+		{name: "cjk", insert: []string{"東京", "東"}, search: "東京", want: true},
+		{name: "emoji", insert: []string{"👍🏽"}, search: "👍🏽", want: true},
+		{name: "invalid utf-8 is replaced", insert: []string{"a\xffb"}, search: "a�b", want: true},
+		{
+			name:   "normalizer",
+			opts:   []TrieOption{WithNormalizer(func(s string) string { return strings.ReplaceAll(s, "e\u0301", "\u00e9") })},
+			insert: []string{"cafe\u0301"},
+			search: "caf\u00e9",
+			want:   true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			trie := InitTrie(tt.opts...)
+			for _, w := range tt.insert {
+				trie.Insert(w)
+			}
+			if got := trie.Search(tt.search); got != tt.want {
+				t.Errorf("Search(%q) = %v, want %v", tt.search, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestTrieDelete(t *testing.T) {
+	trie := InitTrie()
+	for _, w := range []string{"oreo", "oregon", "東京", "東"} {
+		trie.Insert(w)
+	}
+
+	if !trie.Delete("oreo") {
+		t.Error("Delete(\"oreo\") = false, want true")
+	}
+	if trie.Delete("oreo") {
+		t.Error("second Delete(\"oreo\") = true, want false")
+	}
+	if trie.Delete("ore") {
+		t.Error("Delete of a prefix that is not a word = true, want false")
+	}
+	if !trie.Search("oregon") {
+		t.Error("Search(\"oregon\") = false after deleting \"oreo\"")
+	}
+	if !trie.Delete("東京") || !trie.Search("東") {
+		t.Error("deleting \"東京\" removed \"東\"")
+	}
+	if trie.Delete("") {
+		t.Error("Delete(\"\") = true before inserting the empty word")
+	}
+	trie.Insert("")
+	if !trie.Delete("") || trie.Search("") || trie.CountWithPrefix("") != 2 {
+		t.Error("Delete(\"\") did not remove just the empty word")
+	}
+	trie.Delete("oregon")
+	trie.Delete("東")
+	if !hasNoChildren(trie.root) {
+		t.Errorf("root has %d children after deleting every word, want 0", len(trie.root.children))
+	}
+}
+
+// checkTrie verifies that children are sorted, that subtree counts add up
+// and that every leaf ends a word, i.e. Delete left nothing behind.
+func checkTrie(t *testing.T, n *Node) {
+	t.Helper()
+	want := 0
+	if n.isEnd {
+		want = 1
+	}
+	for _, c := range n.children {
+		want += c.count
+	}
+	if n.count != want {
+		t.Fatalf("node %q count = %d, want %d", n.char, n.count, want)
+	}
+	for i, c := range n.children {
+		if i > 0 && n.children[i-1].char >= c.char {
+			t.Fatalf("children of %q out of order", n.char)
+		}
+		if !c.isEnd && hasNoChildren(c) {
+			t.Fatalf("dangling node %q", c.char)
+		}
+		checkTrie(t, c)
+	}
+}
+
+func TestTriePrefixQueries(t *testing.T) {
+	trie := InitTrie()
+	for _, w := range []string{"oreo", "oregon", "ore", "aragon", "argon", "are", "oregon", "éclair"} {
+		trie.Insert(w)
+	}
+
+	tests := []struct {
+		prefix string
+		limit  int
+		want   []string
+	}{
+		{prefix: "ore", want: []string{"ore", "oregon", "oreo"}},
+		{prefix: "ar", want: []string{"aragon", "are", "argon"}},
+		{prefix: "ar", limit: 2, want: []string{"aragon", "are"}},
+		{prefix: "", limit: 3, want: []string{"aragon", "are", "argon"}},
+		{prefix: "é", want: []string{"éclair"}},
+		{prefix: "x"},
+	}
+
+	for _, tt := range tests {
+		got := trie.KeysWithPrefix(tt.prefix, tt.limit)
+		if !slices.Equal(got, tt.want) {
+			t.Errorf("KeysWithPrefix(%q, %d) = %v, want %v", tt.prefix, tt.limit, got, tt.want)
+		}
+		if tt.limit == 0 {
+			if n := trie.CountWithPrefix(tt.prefix); n != len(tt.want) {
+				t.Errorf("CountWithPrefix(%q) = %d, want %d", tt.prefix, n, len(tt.want))
+			}
+			if ok := trie.StartsWith(tt.prefix); ok != (len(tt.want) > 0) {
+				t.Errorf("StartsWith(%q) = %v, want %v", tt.prefix, ok, len(tt.want) > 0)
+			}
+		}
+	}
+
+	if n := trie.CountWithPrefix(""); n != 7 {
+		t.Errorf("CountWithPrefix(\"\") = %d, want 7 (duplicate insert counted once)", n)
+	}
+	trie.Delete("oregon")
+	if n := trie.CountWithPrefix("ore"); n != 2 {
+		t.Errorf("CountWithPrefix(\"ore\") after Delete = %d, want 2", n)
+	}
+	checkTrie(t, trie.root)
+}
+
+func TestTrieTopK(t *testing.T) {
+	trie := InitTrie(WithCaseFolding())
+	trie.InsertWithScore("golang", 90)
+	trie.InsertWithScore("google", 120)
+	trie.InsertWithScore("gopher", 90)
+	trie.InsertWithScore("go", 50)
+	trie.InsertWithScore("graph", 500)
+	trie.Insert("GOAT")
+	trie.Insert("Google") // keeps the existing score
+
+	got := trie.TopK("go", 3)
+	want := []Completion{{"google", 120}, {"golang", 90}, {"gopher", 90}}
+	if !slices.Equal(got, want) {
+		t.Errorf("TopK(\"go\", 3) = %v, want %v", got, want)
+	}
+	if got := trie.TopK("go", 10); len(got) != 5 || got[4] != (Completion{"goat", 0}) {
+		t.Errorf("TopK(\"go\", 10) = %v, want 5 results ending in goat", got)
+	}
+	if got := trie.TopK("x", 3); got != nil {
+		t.Errorf("TopK(\"x\", 3) = %v, want nil", got)
+	}
+
+	var streamed []string
+	for w := range trie.Completions("go") {
+		streamed = append(streamed, w)
+		if len(streamed) == 2 {
+			break
+		}
+	}
+	if !slices.Equal(streamed, []string{"go", "goat"}) {
+		t.Errorf("Completions(\"go\") first two = %v, want [go goat]", streamed)
+	}
+}
+
+func FuzzTrie(f *testing.F) {
+	for _, seed := range []string{"", "a", "argon", "Zürich", "東京", "a\xffb", "K", "\x00"} {
+		f.Add(seed, "aragon", true)
+	}
+
+	f.Fuzz(func(t *testing.T, word, other string, fold bool) {
+		var opts []TrieOption
+		if fold {
+			opts = append(opts, WithCaseFolding())
+		}
+		trie := InitTrie(opts...)
+		trie.Insert(other)
+		trie.Insert(word)
+
+		if !trie.Search(word) {
+			t.Fatalf("Search(%q) = false after Insert", word)
+		}
+		if key := trie.key(word); !utf8.ValidString(key) {
+			t.Fatalf("key(%q) = %q is not valid UTF-8", word, key)
+		}
+		if word != "" && !trie.Delete(word) {
+			t.Fatalf("Delete(%q) = false after Insert", word)
+		}
+		if word != "" && trie.key(word) != trie.key(other) && trie.Search(word) {
+			t.Fatalf("Search(%q) = true after Delete", word)
+		}
+		if !trie.Search(other) && trie.key(word) != trie.key(other) {
+			t.Fatalf("Delete(%q) removed %q", word, other)
+		}
+		checkTrie(t, trie.root)
+	})
+}
@@ -0,0 +1,282 @@
+package main
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+)
+
+// Minimal server side of RFC 6455: the opening handshake, data frames with
+// fragmented messages reassembled, ping/pong and close. Enough to push
+// events to browsers without an external dependency.
+
+const websocketGUID = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+
+const (
+	wsOpContinuation = 0x0
+	wsOpText         = 0x1
+	wsOpBinary       = 0x2
+	wsOpClose        = 0x8
+	wsOpPing         = 0x9
+	wsOpPong         = 0xA
+)
+
+// Close status codes (RFC 6455 section 7.4.1).
+const (
+	wsStatusNormal        = 1000
+	wsStatusProtocolError = 1002
+	wsStatusTooBig        = 1009
+)
+
+// maxWebSocketPayload bounds frames, and reassembled messages, read from
+// clients.
+const maxWebSocketPayload = 1 << 20
+
+var (
+	errNotWebSocket      = errors.New("websocket: not a websocket handshake")
+	errWebSocketProtocol = errors.New("websocket: protocol error")
+	errWebSocketTooBig   = errors.New("websocket: message exceeds limit")
+)
+
+type wsConn struct {
+	conn      net.Conn
+	rw        *bufio.ReadWriter
+	mutex     sync.Mutex // serializes writes
+	closeOnce sync.Once
+}
+
+// wsFrame is a single frame as read off the wire.
+type wsFrame struct {
+	fin     bool
+	masked  bool
+	opcode  byte
+	payload []byte
+}
+
+func isWebSocketRequest(r *http.Request) bool {
+	return headerContains(r.Header, "Connection", "upgrade") &&
+		headerContains(r.Header, "Upgrade", "websocket")
+}
+
+func headerContains(h http.Header, name, token string) bool {
+	for _, v := range h.Values(name) {
+		for _, t := range strings.Split(v, ",") {
+			if strings.EqualFold(strings.TrimSpace(t), token) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func websocketAccept(key string) string {
+	sum := sha1.Sum([]byte(key + websocketGUID))
+	return base64.StdEncoding.EncodeToString(sum[:])
+}
+
+func upgradeWebSocket(w http.ResponseWriter, r *http.Request) (*wsConn, error) {
+	key := r.Header.Get("Sec-WebSocket-Key")
+	if !isWebSocketRequest(r) || key == "" {
+		http.Error(w, "Expected a websocket handshake", http.StatusBadRequest)
+		return nil, errNotWebSocket
+	}
+	hj, ok := w.(http.Hijacker)
+	if !ok {
+		http.Error(w, "Websocket not supported", http.StatusInternalServerError)
+		return nil, errors.New("websocket: response writer cannot be hijacked")
+	}
+	conn, rw, err := hj.Hijack()
+	if err != nil {
+		return nil, err
+	}
+
+	fmt.Fprintf(rw, "HTTP/1.1 101 Switching Protocols\r\n"+
+		"Upgrade: websocket\r\n"+
+		"Connection: Upgrade\r\n"+
+		"Sec-WebSocket-Accept: %s\r\n\r\n", websocketAccept(key))
+	if err := rw.Flush(); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return &wsConn{conn: conn, rw: rw}, nil
+}
+
+func (c *wsConn) WriteText(p []byte) error {
+	return c.writeFrame(wsOpText, p)
+}
+
+func (c *wsConn) writeFrame(opcode byte, p []byte) error {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if err := writeWebSocketFrame(c.rw.Writer, opcode, p, nil); err != nil {
+		return err
+	}
+	return c.rw.Flush()
+}
+
+// writeWebSocketFrame writes a single final frame, masked when mask is set
+// (clients must mask, servers must not).
+func writeWebSocketFrame(w io.Writer, opcode byte, p []byte, mask []byte) error {
+	return writeWebSocketFragment(w, true, opcode, p, mask)
+}
+
+// writeWebSocketFragment writes one frame of a message that is final only if
+// fin is set. Frames after the first carry wsOpContinuation.
+func writeWebSocketFragment(w io.Writer, fin bool, opcode byte, p []byte, mask []byte) error {
+	header := []byte{opcode, 0}
+	if fin {
+		header[0] |= 0x80
+	}
+	var maskBit byte
+	if mask != nil {
+		maskBit = 0x80
+	}
+	switch n := len(p); {
+	case n < 126:
+		header[1] = maskBit | byte(n)
+	case n <= 0xFFFF:
+		header[1] = maskBit | 126
+		header = binary.BigEndian.AppendUint16(header, uint16(n))
+	default:
+		header[1] = maskBit | 127
+		header = binary.BigEndian.AppendUint64(header, uint64(n))
+	}
+	if mask != nil {
+		header = append(header, mask...)
+		masked := make([]byte, len(p))
+		for i := range p {
+			masked[i] = p[i] ^ mask[i%4]
+		}
+		p = masked
+	}
+	if _, err := w.Write(header); err != nil {
+		return err
+	}
+	_, err := w.Write(p)
+	return err
+}
+
+// ReadFrame reads the next data message, answering pings on the way and
+// reassembling fragmented messages. It returns io.EOF once the peer sends a
+// close frame. A frame that breaks the protocol, such as an unmasked one,
+// closes the connection with status 1002 and returns errWebSocketProtocol.
+func (c *wsConn) ReadFrame() (opcode byte, payload []byte, err error) {
+	for {
+		f, err := readWebSocketFrame(c.rw.Reader)
+		if err != nil {
+			return 0, nil, err
+		}
+		// Clients must mask every frame (section 5.1), and control frames
+		// must be final and short (section 5.5).
+		if !f.masked || f.opcode >= wsOpClose && (!f.fin || len(f.payload) > 125) {
+			return 0, nil, c.fail(wsStatusProtocolError, errWebSocketProtocol)
+		}
+
+		switch f.opcode {
+		case wsOpPing:
+			if err := c.writeFrame(wsOpPong, f.payload); err != nil {
+				return 0, nil, err
+			}
+			continue
+		case wsOpPong:
+			continue
+		case wsOpClose:
+			c.sendClose(wsStatusNormal)
+			return 0, nil, io.EOF
+		case wsOpText, wsOpBinary:
+			if opcode != 0 {
+				// A new message started before the fragmented one ended.
+				return 0, nil, c.fail(wsStatusProtocolError, errWebSocketProtocol)
+			}
+			opcode = f.opcode
+		case wsOpContinuation:
+			if opcode == 0 {
+				return 0, nil, c.fail(wsStatusProtocolError, errWebSocketProtocol)
+			}
+		default:
+			return 0, nil, c.fail(wsStatusProtocolError, errWebSocketProtocol)
+		}
+
+		if len(payload)+len(f.payload) > maxWebSocketPayload {
+			return 0, nil, c.fail(wsStatusTooBig, errWebSocketTooBig)
+		}
+		payload = append(payload, f.payload...)
+		if f.fin {
+			return opcode, payload, nil
+		}
+	}
+}
+
+func readWebSocketFrame(r io.Reader) (wsFrame, error) {
+	var f wsFrame
+	var head [2]byte
+	if _, err := io.ReadFull(r, head[:]); err != nil {
+		return f, err
+	}
+	f.fin = head[0]&0x80 != 0
+	f.opcode = head[0] & 0x0F
+	f.masked = head[1]&0x80 != 0
+
+	n := uint64(head[1] & 0x7F)
+	switch n {
+	case 126:
+		var ext [2]byte
+		if _, err := io.ReadFull(r, ext[:]); err != nil {
+			return f, err
+		}
+		n = uint64(binary.BigEndian.Uint16(ext[:]))
+	case 127:
+		var ext [8]byte
+		if _, err := io.ReadFull(r, ext[:]); err != nil {
+			return f, err
+		}
+		n = binary.BigEndian.Uint64(ext[:])
+	}
+	if n > maxWebSocketPayload {
+		return f, fmt.Errorf("websocket: frame of %d bytes exceeds limit", n)
+	}
+
+	var mask [4]byte
+	if f.masked {
+		if _, err := io.ReadFull(r, mask[:]); err != nil {
+			return f, err
+		}
+	}
+	f.payload = make([]byte, n)
+	if _, err := io.ReadFull(r, f.payload); err != nil {
+		return f, err
+	}
+	if f.masked {
+		for i := range f.payload {
+			f.payload[i] ^= mask[i%4]
+		}
+	}
+	return f, nil
+}
+
+// sendClose writes a close frame with status code, once per connection.
+func (c *wsConn) sendClose(code uint16) {
+	c.closeOnce.Do(func() {
+		c.writeFrame(wsOpClose, binary.BigEndian.AppendUint16(nil, code))
+	})
+}
+
+// fail closes the connection with status code and returns err.
+func (c *wsConn) fail(code uint16, err error) error {
+	c.sendClose(code)
+	c.conn.Close()
+	return err
+}
+
+func (c *wsConn) Close() error {
+	c.sendClose(wsStatusNormal)
+	return c.conn.Close()
+}
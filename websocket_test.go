@@ -0,0 +1,149 @@
+package main
+
+import (
+	"bufio"
+	"encoding/binary"
+	"io"
+	"net"
+	"testing"
+)
+
+// wsTestFrame is a frame written by the fake client in TestWebSocketReadFrame.
+type wsTestFrame struct {
+	fin     bool
+	opcode  byte
+	payload string
+	masked  bool
+}
+
+func TestWebSocketReadFrame(t *testing.T) {
+	mask := []byte{1, 2, 3, 4}
+	tests := []struct {
+		name        string
+		frames      []wsTestFrame
+		wantOpcode  byte
+		wantPayload string
+		wantErr     error
+		wantReply   []byte // opcode of every frame the server sends back
+		wantStatus  uint16 // status of the close frame, if any
+	}{
+		{
+			name:        "single frame",
+			frames:      []wsTestFrame{{fin: true, opcode: wsOpText, payload: "hello", masked: true}},
+			wantOpcode:  wsOpText,
+			wantPayload: "hello",
+		},
+		{
+			name: "fragments with a ping in between",
+			frames: []wsTestFrame{
+				{opcode: wsOpText, payload: "hel", masked: true},
+				{fin: true, opcode: wsOpPing, payload: "p", masked: true},
+				{opcode: wsOpContinuation, payload: "l", masked: true},
+				{fin: true, opcode: wsOpContinuation, payload: "o", masked: true},
+			},
+			wantOpcode:  wsOpText,
+			wantPayload: "hello",
+			wantReply:   []byte{wsOpPong},
+		},
+		{
+			name:       "unmasked frame",
+			frames:     []wsTestFrame{{fin: true, opcode: wsOpText, payload: "hello"}},
+			wantErr:    errWebSocketProtocol,
+			wantReply:  []byte{wsOpClose},
+			wantStatus: wsStatusProtocolError,
+		},
+		{
+			name:       "continuation without a message",
+			frames:     []wsTestFrame{{fin: true, opcode: wsOpContinuation, payload: "lo", masked: true}},
+			wantErr:    errWebSocketProtocol,
+			wantReply:  []byte{wsOpClose},
+			wantStatus: wsStatusProtocolError,
+		},
+		{
+			name: "new message inside a fragmented one",
+			frames: []wsTestFrame{
+				{opcode: wsOpText, payload: "hel", masked: true},
+				{fin: true, opcode: wsOpText, payload: "lo", masked: true},
+			},
+			wantErr:    errWebSocketProtocol,
+			wantReply:  []byte{wsOpClose},
+			wantStatus: wsStatusProtocolError,
+		},
+		{
+			name:       "fragmented ping",
+			frames:     []wsTestFrame{{opcode: wsOpPing, payload: "p", masked: true}},
+			wantErr:    errWebSocketProtocol,
+			wantReply:  []byte{wsOpClose},
+			wantStatus: wsStatusProtocolError,
+		},
+		{
+			// A normal closure is answered with 1000.
+			name:       "close",
+			frames:     []wsTestFrame{{fin: true, opcode: wsOpClose, masked: true}},
+			wantErr:    io.EOF,
+			wantReply:  []byte{wsOpClose},
+			wantStatus: wsStatusNormal,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server, client := net.Pipe()
+			defer client.Close()
+			c := &wsConn{conn: server, rw: bufio.NewReadWriter(bufio.NewReader(server), bufio.NewWriter(server))}
+			defer c.conn.Close()
+
+			go func() {
+				for _, f := range tt.frames {
+					m := mask
+					if !f.masked {
+						m = nil
+					}
+					if writeWebSocketFragment(client, f.fin, f.opcode, []byte(f.payload), m) != nil {
+						return
+					}
+				}
+			}()
+			replies := make(chan []wsFrame)
+			go func() {
+				var got []wsFrame
+				r := bufio.NewReader(client)
+				for {
+					f, err := readWebSocketFrame(r)
+					if err != nil {
+						replies <- got
+						return
+					}
+					got = append(got, f)
+				}
+			}()
+
+			opcode, payload, err := c.ReadFrame()
+			if err != tt.wantErr {
+				t.Fatalf("ReadFrame() error = %v, want %v", err, tt.wantErr)
+			}
+			if err == nil && (opcode != tt.wantOpcode || string(payload) != tt.wantPayload) {
+				t.Errorf("ReadFrame() = (%d, %q), want (%d, %q)", opcode, payload, tt.wantOpcode, tt.wantPayload)
+			}
+
+			c.conn.Close()
+			got := <-replies
+			var ops []byte
+			for _, f := range got {
+				ops = append(ops, f.opcode)
+				if f.masked {
+					t.Error("server sent a masked frame")
+				}
+			}
+			if string(ops) != string(tt.wantReply) {
+				t.Errorf("server replied with opcodes %v, want %v", ops, tt.wantReply)
+			}
+			if tt.wantStatus != 0 && len(got) > 0 {
+				last := got[len(got)-1].payload
+				if len(last) < 2 || binary.BigEndian.Uint16(last) != tt.wantStatus {
+					t.Errorf("close payload = %v, want status %d", last, tt.wantStatus)
+				}
+			}
+		})
+	}
+}
@@ -1,61 +1,400 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"sync"
 	"time"
 )
 
+// Task is a unit of work run by a WorkerPool. Process should return
+// promptly with ctx.Err() once ctx is done.
 type Task interface {
+	Process(ctx context.Context) error
+}
+
+// TaskFunc lets an ordinary function be used as a Task.
+type TaskFunc func(ctx context.Context) error
+
+func (f TaskFunc) Process(ctx context.Context) error {
+	return f(ctx)
+}
+
+// LegacyTask is the original task interface, with no way to cancel the work
+// or report failure.
+type LegacyTask interface {
 	Process()
 }
 
-type EmailTask struct{
-	Email        string 
-	Subject      string 
-	MessageBody  string
+// FromLegacy adapts a LegacyTask to Task. Since the wrapped task cannot be
+// interrupted, a cancelled or timed-out run returns ctx.Err() straight away
+// and leaves the call to finish in the background, so a stuck task no
+// longer holds up its worker.
+func FromLegacy(t LegacyTask) Task {
+	return TaskFunc(func(ctx context.Context) error {
+		done := make(chan struct{})
+		go func() {
+			defer close(done)
+			t.Process()
+		}()
+		select {
+		case <-done:
+			return nil
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	})
 }
 
-func (t *EmailTask) Process(){
+type EmailTask struct {
+	Email       string
+	Subject     string
+	MessageBody string
+}
+
+func (t *EmailTask) Process(ctx context.Context) error {
 	fmt.Printf("Sending email to %s\n", t.Email)
-	time.Sleep(2*time.Second)
+	return sleepContext(ctx, 2*time.Second)
 }
 
-type ImageProcessingTask struct{
-	ImageUrl  string 
+type ImageProcessingTask struct {
+	ImageUrl string
 }
 
-func (t *ImageProcessingTask) Process(){
+func (t *ImageProcessingTask) Process(ctx context.Context) error {
 	fmt.Printf("Processing the image %s\n", t.ImageUrl)
-	time.Sleep(5*time.Second)
+	return sleepContext(ctx, 5*time.Second)
+}
+
+// sleepContext stands in for real work taking d, giving up early when ctx is
+// done.
+func sleepContext(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
 }
 
+var (
+	ErrPoolStopped = errors.New("worker pool stopped")
+	ErrQueueFull   = errors.New("worker pool queue full")
+)
+
+// WorkerPool runs tasks on a fixed number of workers. It can be used in two
+// ways: Run processes the batch in Tasks and returns, while Start keeps the
+// workers alive to process tasks handed over with Submit until Stop or
+// StopAndDrain. The two modes must not be mixed on one pool.
 type WorkerPool struct {
-	Tasks       []Task 
-	concurrency  int 
-	tasksChan   chan Task 
+	Tasks       []Task
+	concurrency int
+	tasksChan   chan indexedTask
 	wg          sync.WaitGroup
+
+	// Limiter, when set, caps how many workers process tasks at once at a
+	// limit that adapts to task latency.
+	Limiter *AdaptiveLimiter
+
+	// TaskTimeout, when positive, bounds how long each task may run.
+	TaskTimeout time.Duration
+
+	errs  []error
+	mutex sync.Mutex
+
+	// State of a long-lived pool. Submitters hold stateMutex for reading
+	// while they send on queue, so once Stop holds it for writing and sets
+	// stopped nobody can send any more and queue can be closed.
+	queueSize  int
+	queue      chan *Future
+	quit       chan struct{} // closed to abort blocked submitters
+	ctx        context.Context
+	cancel     context.CancelFunc
+	stopped    bool
+	stateMutex sync.RWMutex
+	workers    sync.WaitGroup
+	initOnce   sync.Once
+	startOnce  sync.Once
+	stopOnce   sync.Once
+}
+
+// NewWorkerPool creates a long-lived pool of concurrency workers whose queue
+// holds up to queueSize tasks waiting for a worker. Call Start to begin
+// processing.
+func NewWorkerPool(concurrency, queueSize int) *WorkerPool {
+	return &WorkerPool{concurrency: concurrency, queueSize: queueSize}
+}
+
+type indexedTask struct {
+	index int
+	task  Task
 }
 
-func (wp *WorkerPool) worker() {
-	for task := range wp.tasksChan {
-		task.Process()
+func (wp *WorkerPool) worker(ctx context.Context, tasks <-chan indexedTask) {
+	for t := range tasks {
+		if err := wp.process(ctx, t.task); err != nil {
+			wp.mutex.Lock()
+			wp.errs = append(wp.errs, fmt.Errorf("task %d: %w", t.index, err))
+			wp.mutex.Unlock()
+		}
 		wp.wg.Done()
 	}
 }
 
-func (wp *WorkerPool) Run() {
-	wp.tasksChan = make(chan Task, len(wp.Tasks))
+func (wp *WorkerPool) process(ctx context.Context, task Task) error {
+	if wp.TaskTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, wp.TaskTimeout)
+		defer cancel()
+	}
+	if wp.Limiter == nil {
+		return task.Process(ctx)
+	}
+
+	slot, err := wp.Limiter.Wait(ctx)
+	if err != nil {
+		return err
+	}
+	err = task.Process(ctx)
+	switch {
+	case err == nil:
+		slot.Success()
+	case errors.Is(err, context.DeadlineExceeded):
+		// A timed-out task is the overload signal the limiter backs off on.
+		slot.Dropped()
+	default:
+		slot.Ignore()
+	}
+	return err
+}
+
+// Run processes every task in wp.Tasks and waits for them to finish. Once
+// ctx is done no further tasks are started; tasks already running see the
+// cancellation through their context. The returned error joins the error of
+// every failed task with, after a cancellation, the number of tasks that
+// were never started. A concurrency below one runs a single worker.
+func (wp *WorkerPool) Run(ctx context.Context) error {
+	wp.tasksChan = make(chan indexedTask)
+	wp.errs = nil
 
-	for i := 0; i < wp.concurrency; i++{
-		go wp.worker()
+	for i := 0; i < max(wp.concurrency, 1); i++ {
+		go wp.worker(ctx, wp.tasksChan)
 	}
 
-	wp.wg.Add(len(wp.Tasks))
-	for _, task := range wp.Tasks {
-		wp.tasksChan <- task
+	dispatched := 0
+dispatch:
+	for i, task := range wp.Tasks {
+		if ctx.Err() != nil {
+			break
+		}
+		wp.wg.Add(1)
+		select {
+		case wp.tasksChan <- indexedTask{index: i, task: task}:
+			dispatched++
+		case <-ctx.Done():
+			wp.wg.Done()
+			break dispatch
+		}
 	}
 	close(wp.tasksChan)
 
 	wp.wg.Wait()
+	errs := wp.errs
+	if skipped := len(wp.Tasks) - dispatched; skipped > 0 {
+		errs = append(errs, fmt.Errorf("%d of %d tasks not started: %w", skipped, len(wp.Tasks), context.Cause(ctx)))
+	}
+	return errors.Join(errs...)
+}
+
+// Future is the pending outcome of a task handed to Submit. A Task only
+// reports an error, so that is all a Future carries; use SubmitFunc for
+// tasks that produce a value.
+type Future struct {
+	task Task
+	done chan struct{}
+	err  error
+}
+
+func newFuture(task Task) *Future {
+	return &Future{task: task, done: make(chan struct{})}
+}
+
+func (f *Future) complete(err error) {
+	f.err = err
+	close(f.done)
+}
+
+// Done is closed once the task has finished or been discarded by Stop.
+func (f *Future) Done() <-chan struct{} {
+	return f.done
+}
+
+// Wait blocks until the task has finished and returns its error, or returns
+// ctx.Err() if ctx is done first. A task discarded by Stop before it ran
+// reports ErrPoolStopped.
+func (f *Future) Wait(ctx context.Context) error {
+	select {
+	case <-f.done:
+		return f.err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// ValueFuture is the pending result of a function handed to SubmitFunc.
+type ValueFuture[T any] struct {
+	*Future
+	value T
+}
+
+// Get blocks until the function has returned and returns its value and
+// error, or the zero value and ctx.Err() if ctx is done first.
+func (f *ValueFuture[T]) Get(ctx context.Context) (T, error) {
+	select {
+	case <-f.done:
+		return f.value, f.err
+	case <-ctx.Done():
+		var zero T
+		return zero, ctx.Err()
+	}
+}
+
+// SubmitFunc queues fn like Submit and returns a future for its value. A
+// function discarded by Stop before it ran yields the zero value and
+// ErrPoolStopped.
+func SubmitFunc[T any](wp *WorkerPool, fn func(ctx context.Context) (T, error)) (*ValueFuture[T], error) {
+	vf := &ValueFuture[T]{}
+	f, err := wp.Submit(TaskFunc(func(ctx context.Context) error {
+		v, err := fn(ctx)
+		vf.value = v
+		return err
+	}))
+	if err != nil {
+		return nil, err
+	}
+	vf.Future = f
+	return vf, nil
+}
+
+func (wp *WorkerPool) init() {
+	wp.initOnce.Do(func() {
+		wp.queue = make(chan *Future, wp.queueSize)
+		wp.quit = make(chan struct{})
+		wp.ctx, wp.cancel = context.WithCancel(context.Background())
+	})
+}
+
+// Start launches the workers. Calling it again has no effect.
+func (wp *WorkerPool) Start() {
+	wp.init()
+	wp.startOnce.Do(func() {
+		for i := 0; i < max(wp.concurrency, 1); i++ {
+			wp.workers.Add(1)
+			go wp.serve()
+		}
+	})
+}
+
+func (wp *WorkerPool) serve() {
+	defer wp.workers.Done()
+	for f := range wp.queue {
+		if wp.ctx.Err() != nil {
+			f.complete(ErrPoolStopped)
+			continue
+		}
+		f.complete(wp.process(wp.ctx, f.task))
+	}
+}
+
+// Submit queues task, blocking while the queue is full, and returns a
+// Future for its result. It fails with ErrPoolStopped once the pool is
+// stopping.
+func (wp *WorkerPool) Submit(task Task) (*Future, error) {
+	return wp.submit(context.Background(), task, true)
+}
+
+// TrySubmit is like Submit but fails with ErrQueueFull instead of blocking.
+func (wp *WorkerPool) TrySubmit(task Task) (*Future, error) {
+	return wp.submit(context.Background(), task, false)
+}
+
+// SubmitWait queues task and waits for it to finish, returning its error.
+// If ctx is done first, waiting stops with ctx.Err(); a task that was
+// already queued still runs.
+func (wp *WorkerPool) SubmitWait(ctx context.Context, task Task) error {
+	f, err := wp.submit(ctx, task, true)
+	if err != nil {
+		return err
+	}
+	return f.Wait(ctx)
+}
+
+func (wp *WorkerPool) submit(ctx context.Context, task Task, block bool) (*Future, error) {
+	wp.init()
+	wp.stateMutex.RLock()
+	defer wp.stateMutex.RUnlock()
+	if wp.stopped {
+		return nil, ErrPoolStopped
+	}
+
+	f := newFuture(task)
+	if !block {
+		select {
+		case wp.queue <- f:
+			return f, nil
+		default:
+			return nil, ErrQueueFull
+		}
+	}
+	select {
+	case wp.queue <- f:
+		return f, nil
+	case <-wp.quit:
+		return nil, ErrPoolStopped
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// Stop stops accepting tasks, cancels the context of running tasks and
+// discards queued ones, whose futures report ErrPoolStopped. It returns
+// once every worker has exited.
+func (wp *WorkerPool) Stop() {
+	wp.init()
+	wp.cancel()
+	wp.shutdown()
+}
+
+// StopAndDrain stops accepting tasks and returns once every task already
+// queued has run. If Start was never called the queued tasks run one by one
+// on the calling goroutine.
+func (wp *WorkerPool) StopAndDrain() {
+	wp.init()
+	wp.shutdown()
+}
+
+func (wp *WorkerPool) shutdown() {
+	wp.stopOnce.Do(func() {
+		close(wp.quit)
+		wp.stateMutex.Lock()
+		wp.stopped = true
+		close(wp.queue)
+		wp.stateMutex.Unlock()
+	})
+	wp.workers.Wait()
+
+	// Without workers, because Start was never called, nothing else will
+	// complete what is left in the queue. Stop has already cancelled ctx,
+	// so only StopAndDrain runs the tasks.
+	for f := range wp.queue {
+		if wp.ctx.Err() != nil {
+			f.complete(ErrPoolStopped)
+			continue
+		}
+		f.complete(wp.process(wp.ctx, f.task))
+	}
+	wp.cancel()
 }
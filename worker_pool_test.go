@@ -0,0 +1,318 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+type stuckTask struct{ release chan struct{} }
+
+func (t stuckTask) Process() { <-t.release }
+
+func TestWorkerPoolRunAggregatesErrors(t *testing.T) {
+	errBoom := errors.New("boom")
+	var ran atomic.Int32
+	ok := TaskFunc(func(ctx context.Context) error {
+		ran.Add(1)
+		return nil
+	})
+	fail := TaskFunc(func(ctx context.Context) error {
+		ran.Add(1)
+		return errBoom
+	})
+
+	wp := WorkerPool{Tasks: []Task{ok, fail, ok, fail}, concurrency: 2}
+	err := wp.Run(context.Background())
+	if !errors.Is(err, errBoom) {
+		t.Fatalf("Run() error = %v, want it to wrap %v", err, errBoom)
+	}
+	if msg := err.Error(); !strings.Contains(msg, "task 1: boom") || !strings.Contains(msg, "task 3: boom") {
+		t.Errorf("Run() error = %q, want both failing task indexes", msg)
+	}
+	if ran.Load() != 4 {
+		t.Errorf("%d tasks ran, want 4", ran.Load())
+	}
+
+	wp.Tasks = []Task{ok}
+	if err := wp.Run(context.Background()); err != nil {
+		t.Errorf("second Run() error = %v, want nil", err)
+	}
+}
+
+func TestWorkerPoolRunWithoutConcurrency(t *testing.T) {
+	var ran atomic.Int32
+	task := TaskFunc(func(ctx context.Context) error {
+		ran.Add(1)
+		return nil
+	})
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	wp := WorkerPool{Tasks: []Task{task, task, task}}
+	if err := wp.Run(ctx); err != nil {
+		t.Fatalf("Run() with zero concurrency = %v, want nil", err)
+	}
+	if ran.Load() != 3 {
+		t.Errorf("%d tasks ran, want 3", ran.Load())
+	}
+}
+
+func TestWorkerPoolTaskTimeout(t *testing.T) {
+	release := make(chan struct{})
+	defer close(release)
+
+	wp := WorkerPool{
+		Tasks:       []Task{FromLegacy(stuckTask{release}), TaskFunc(func(context.Context) error { return nil })},
+		concurrency: 1,
+		TaskTimeout: 20 * time.Millisecond,
+	}
+	start := time.Now()
+	err := wp.Run(context.Background())
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Run() error = %v, want %v", err, context.DeadlineExceeded)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("Run() took %v, the stuck task was not abandoned", elapsed)
+	}
+}
+
+func TestWorkerPoolCancellationStopsDispatch(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	var started atomic.Int32
+	task := TaskFunc(func(ctx context.Context) error {
+		if started.Add(1) == 2 {
+			cancel()
+		}
+		return sleepContext(ctx, time.Minute)
+	})
+
+	wp := WorkerPool{Tasks: []Task{task, task, task, task, task, task}, concurrency: 2}
+	err := wp.Run(ctx)
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("Run() error = %v, want %v", err, context.Canceled)
+	}
+	if n := started.Load(); n != 2 {
+		t.Errorf("%d tasks started, want 2", n)
+	}
+	if !strings.Contains(err.Error(), "4 of 6 tasks not started") {
+		t.Errorf("Run() error = %q, want the number of tasks not started", err)
+	}
+}
+
+func TestWorkerPoolLimiterBacksOffOnTimeouts(t *testing.T) {
+	al := NewAdaptiveLimiter(4, &AIMD{Backoff: 0.5, Min: 1, Max: 4})
+	slow := TaskFunc(func(ctx context.Context) error { return sleepContext(ctx, time.Minute) })
+	wp := WorkerPool{
+		Tasks:       []Task{slow},
+		concurrency: 1,
+		Limiter:     al,
+		TaskTimeout: time.Millisecond,
+	}
+	wp.Run(context.Background())
+	if al.Limit() != 2 {
+		t.Errorf("Limit() = %d after a timed-out task, want 2", al.Limit())
+	}
+}
+
+func TestWorkerPoolSubmit(t *testing.T) {
+	wp := NewWorkerPool(2, 4)
+	wp.Start()
+	defer wp.Stop()
+
+	errBoom := errors.New("boom")
+	ok, err := wp.Submit(TaskFunc(func(context.Context) error { return nil }))
+	if err != nil {
+		t.Fatal(err)
+	}
+	failed, err := wp.Submit(TaskFunc(func(context.Context) error { return errBoom }))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx := context.Background()
+	if err := ok.Wait(ctx); err != nil {
+		t.Errorf("Wait() = %v, want nil", err)
+	}
+	if err := failed.Wait(ctx); err != errBoom {
+		t.Errorf("Wait() = %v, want %v", err, errBoom)
+	}
+	if err := wp.SubmitWait(ctx, TaskFunc(func(context.Context) error { return errBoom })); err != errBoom {
+		t.Errorf("SubmitWait() = %v, want %v", err, errBoom)
+	}
+}
+
+func TestWorkerPoolTrySubmitQueueFull(t *testing.T) {
+	wp := NewWorkerPool(1, 1)
+	release := make(chan struct{})
+	running := make(chan struct{})
+	blocker := TaskFunc(func(context.Context) error {
+		close(running)
+		<-release
+		return nil
+	})
+	wp.Start()
+
+	if _, err := wp.TrySubmit(blocker); err != nil {
+		t.Fatal(err)
+	}
+	<-running // the worker is busy, so the queue slot is free again
+	if _, err := wp.TrySubmit(TaskFunc(func(context.Context) error { return nil })); err != nil {
+		t.Fatalf("TrySubmit() with a free queue slot error = %v", err)
+	}
+	if _, err := wp.TrySubmit(TaskFunc(func(context.Context) error { return nil })); err != ErrQueueFull {
+		t.Errorf("TrySubmit() on a full queue error = %v, want %v", err, ErrQueueFull)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if err := wp.SubmitWait(ctx, TaskFunc(func(context.Context) error { return nil })); err != context.DeadlineExceeded {
+		t.Errorf("SubmitWait() on a full queue error = %v, want %v", err, context.DeadlineExceeded)
+	}
+	close(release)
+	wp.StopAndDrain()
+}
+
+func TestWorkerPoolStopVersusDrain(t *testing.T) {
+	tests := []struct {
+		name       string
+		stop       func(wp *WorkerPool)
+		wantQueued error
+		wantRan    int32
+	}{
+		{name: "drain runs queued tasks", stop: (*WorkerPool).StopAndDrain, wantQueued: nil, wantRan: 4},
+		{name: "stop discards queued tasks", stop: (*WorkerPool).Stop, wantQueued: ErrPoolStopped, wantRan: 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			wp := NewWorkerPool(1, 8)
+			var ran atomic.Int32
+			running := make(chan struct{})
+			first := TaskFunc(func(ctx context.Context) error {
+				ran.Add(1)
+				close(running)
+				return sleepContext(ctx, 20*time.Millisecond)
+			})
+			task := TaskFunc(func(ctx context.Context) error {
+				ran.Add(1)
+				return nil
+			})
+
+			wp.Start()
+			wp.Submit(first)
+			<-running
+			var futures []*Future
+			for i := 0; i < 3; i++ {
+				f, err := wp.Submit(task)
+				if err != nil {
+					t.Fatal(err)
+				}
+				futures = append(futures, f)
+			}
+
+			tt.stop(wp)
+			if ran.Load() != tt.wantRan {
+				t.Errorf("%d tasks ran, want %d", ran.Load(), tt.wantRan)
+			}
+			for _, f := range futures {
+				if err := f.Wait(context.Background()); err != tt.wantQueued {
+					t.Errorf("queued task Wait() = %v, want %v", err, tt.wantQueued)
+				}
+			}
+			if _, err := wp.Submit(task); err != ErrPoolStopped {
+				t.Errorf("Submit() after stopping error = %v, want %v", err, ErrPoolStopped)
+			}
+		})
+	}
+}
+
+func TestWorkerPoolStopWithoutStart(t *testing.T) {
+	tests := []struct {
+		name       string
+		stop       func(wp *WorkerPool)
+		wantQueued error
+		wantRan    int32
+	}{
+		{name: "drain runs queued tasks inline", stop: (*WorkerPool).StopAndDrain, wantQueued: nil, wantRan: 3},
+		{name: "stop discards queued tasks", stop: (*WorkerPool).Stop, wantQueued: ErrPoolStopped, wantRan: 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			wp := NewWorkerPool(2, 8)
+			var ran atomic.Int32
+			var futures []*Future
+			for i := 0; i < 3; i++ {
+				f, err := wp.Submit(TaskFunc(func(ctx context.Context) error {
+					ran.Add(1)
+					return nil
+				}))
+				if err != nil {
+					t.Fatal(err)
+				}
+				futures = append(futures, f)
+			}
+
+			tt.stop(wp)
+			if ran.Load() != tt.wantRan {
+				t.Errorf("%d tasks ran, want %d", ran.Load(), tt.wantRan)
+			}
+			for _, f := range futures {
+				if err := f.Wait(context.Background()); err != tt.wantQueued {
+					t.Errorf("queued task Wait() = %v, want %v", err, tt.wantQueued)
+				}
+			}
+		})
+	}
+}
+
+func TestSubmitFunc(t *testing.T) {
+	wp := NewWorkerPool(2, 4)
+	wp.Start()
+	defer wp.Stop()
+
+	errOdd := errors.New("odd")
+	var futures []*ValueFuture[int]
+	for i := 0; i < 4; i++ {
+		f, err := SubmitFunc(wp, func(ctx context.Context) (int, error) {
+			if i%2 == 1 {
+				return 0, errOdd
+			}
+			return i * i, nil
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		futures = append(futures, f)
+	}
+
+	for i, f := range futures {
+		v, err := f.Get(context.Background())
+		switch {
+		case i%2 == 1 && err != errOdd:
+			t.Errorf("future %d error = %v, want %v", i, err, errOdd)
+		case i%2 == 0 && (err != nil || v != i*i):
+			t.Errorf("future %d = %d, %v, want %d, nil", i, v, err, i*i)
+		}
+	}
+}
+
+func TestWorkerPoolStopUnblocksSubmitters(t *testing.T) {
+	wp := NewWorkerPool(1, 1)
+	wp.Submit(TaskFunc(func(context.Context) error { return nil })) // fills the queue, not started
+
+	errc := make(chan error)
+	go func() {
+		_, err := wp.Submit(TaskFunc(func(context.Context) error { return nil }))
+		errc <- err
+	}()
+	time.Sleep(10 * time.Millisecond)
+	wp.Stop()
+	if err := <-errc; err != ErrPoolStopped {
+		t.Errorf("blocked Submit() error = %v, want %v", err, ErrPoolStopped)
+	}
+}